@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type Outcome string
+
+const (
+	Success Outcome = "success"
+	Failure Outcome = "failure"
+	Denied  Outcome = "denied"
+)
+
+// Event is a single security-relevant action. Type names the action, e.g.
+// "auth.login"; Reason explains failures and denials. Actor is set when the
+// action was taken by someone other than Sub, e.g. an admin impersonating
+// the user.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Outcome   Outcome   `json:"outcome"`
+	Sub       string    `json:"sub,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Sink stores or forwards events. Record is called on the request path, so
+// slow sinks should buffer.
+type Sink interface {
+	Record(ctx context.Context, ev Event) error
+}
+
+type SinkFunc func(ctx context.Context, ev Event) error
+
+func (f SinkFunc) Record(ctx context.Context, ev Event) error {
+	return f(ctx, ev)
+}
+
+// Multi records each event in every sink, returning the joined errors.
+func Multi(sinks ...Sink) Sink {
+	return SinkFunc(func(ctx context.Context, ev Event) error {
+		var errs []error
+		for _, s := range sinks {
+			if err := s.Record(ctx, ev); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// FromRequest returns an event of the given type with the time and the
+// request's client details filled in. IP is taken from RemoteAddr; install
+// chi's RealIP middleware to honour proxy headers.
+func FromRequest(r *http.Request, typ string, outcome Outcome) Event {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	return Event{
+		Time:      time.Now(),
+		Type:      typ,
+		Outcome:   outcome,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+}
@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekmwright/web/nats"
+)
+
+func TestRedactHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    func(*slog.Logger)
+		secret string
+		keep   string
+	}{
+		{
+			name:   "attribute",
+			log:    func(l *slog.Logger) { l.Info("login", "state", "s3cr3t-state", "sub", "alice") },
+			secret: "s3cr3t-state",
+			keep:   "alice",
+		},
+		{
+			name:   "case insensitive",
+			log:    func(l *slog.Logger) { l.Info("request", "Authorization", "Bearer abc.def.ghi") },
+			secret: "abc.def.ghi",
+			keep:   "request",
+		},
+		{
+			name:   "group",
+			log:    func(l *slog.Logger) { l.Info("token", slog.Group("oauth", "access_token", "at-123", "type", "Bearer")) },
+			secret: "at-123",
+			keep:   "Bearer",
+		},
+		{
+			name:   "with attrs",
+			log:    func(l *slog.Logger) { l.With("refresh_token", "rt-456").Info("refresh") },
+			secret: "rt-456",
+			keep:   "refresh",
+		},
+		{
+			name: "url query",
+			log: func(l *slog.Logger) {
+				l.Info("callback", "url", "https://app.test/callback?code=c0de&state=st&lang=en")
+			},
+			secret: "c0de",
+			keep:   "lang=en",
+		},
+		{
+			name:   "message",
+			log:    func(l *slog.Logger) { l.Info("exchanging code=c0de for tokens", "sub", "alice") },
+			secret: "c0de",
+			keep:   "exchanging code=",
+		},
+		{
+			name: "error value",
+			log: func(l *slog.Logger) {
+				err := fmt.Errorf("token exchange: %w", errors.New(`oauth2: "invalid_grant" {"refresh_token":"rt-789","error":"expired"}`))
+				l.Error("refresh failed", "error", err)
+			},
+			secret: "rt-789",
+			keep:   "invalid_grant",
+		},
+		{
+			name:   "bearer in text",
+			log:    func(l *slog.Logger) { l.Warn("upstream rejected", "detail", "sent Authorization: Bearer abcdefgh1234") },
+			secret: "abcdefgh1234",
+			keep:   "upstream rejected",
+		},
+		{
+			name:   "jwt in text",
+			log:    func(l *slog.Logger) { l.Warn("bad token eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJhbGljZSJ9.c2ln") },
+			secret: "eyJzdWIiOiJhbGljZSJ9",
+			keep:   "bad token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(slog.New(NewRedactHandler(slog.NewJSONHandler(&buf, nil))))
+
+			out := buf.String()
+			if strings.Contains(out, tt.secret) {
+				t.Errorf("output leaks %q: %s", tt.secret, out)
+			}
+			if !strings.Contains(out, tt.keep) {
+				t.Errorf("output lost %q: %s", tt.keep, out)
+			}
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/callback", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.Header.Set("User-Agent", "test-agent")
+
+	ev := FromRequest(r, "auth.login", Failure)
+	if ev.IP != "203.0.113.7" || ev.UserAgent != "test-agent" || ev.Path != "/callback" || ev.Time.IsZero() {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	var buf bytes.Buffer
+	slogSink, err := NewSlogSink(slog.New(slog.NewJSONHandler(&buf, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nc, shutdown, err := nats.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	natsSink, err := NewNATSSink(nc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := nc.SubscribeSync("audit.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := SinkFunc(func(context.Context, Event) error { return errors.New("down") })
+
+	ev := Event{Time: time.Now(), Type: "auth.logout", Outcome: Success, Sub: "alice"}
+	if err = Multi(slogSink, failing, natsSink).Record(t.Context(), ev); err == nil {
+		t.Error("expected error from failing sink")
+	}
+
+	if !strings.Contains(buf.String(), `"type":"auth.logout"`) {
+		t.Errorf("slog sink output = %s", buf.String())
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "audit.auth.logout" {
+		t.Errorf("subject = %q, want audit.auth.logout", msg.Subject)
+	}
+
+	var got Event
+	if err = json.Unmarshal(msg.Data, &got); err != nil || got.Sub != "alice" {
+		t.Errorf("event = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,9 @@
+package audit
+
+import "errors"
+
+var (
+	ErrNilDatabase = errors.New("database cannot be nil")
+	ErrNilLogger   = errors.New("logger cannot be nil")
+	ErrNilConn     = errors.New("nats connection cannot be nil")
+)
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS audit_events (
+    id          BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    type        TEXT NOT NULL,
+    outcome     TEXT NOT NULL,
+    sub         TEXT NOT NULL DEFAULT '',
+    tenant      TEXT NOT NULL DEFAULT '',
+    reason      TEXT NOT NULL DEFAULT '',
+    ip          TEXT NOT NULL DEFAULT '',
+    user_agent  TEXT NOT NULL DEFAULT '',
+    method      TEXT NOT NULL DEFAULT '',
+    path        TEXT NOT NULL DEFAULT '',
+    request_id  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_sub_idx ON audit_events (sub, occurred_at);
+CREATE INDEX IF NOT EXISTS audit_events_type_idx ON audit_events (type, occurred_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_events;
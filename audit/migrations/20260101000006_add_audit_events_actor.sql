@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS actor TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor, occurred_at) WHERE actor <> '';
+
+-- +goose Down
+DROP INDEX IF EXISTS audit_events_actor_idx;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS actor;
@@ -0,0 +1,33 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSSink publishes events as JSON to subject.<type>, e.g.
+// "audit.auth.login", so consumers can subscribe to "audit.>" or a subset.
+type NATSSink struct {
+	nc      *nats.Conn
+	subject string
+}
+
+func NewNATSSink(nc *nats.Conn, subject string) (*NATSSink, error) {
+	if nc == nil {
+		return nil, ErrNilConn
+	}
+	if subject == "" {
+		subject = "audit"
+	}
+	return &NATSSink{nc: nc, subject: subject}, nil
+}
+
+func (s *NATSSink) Record(ctx context.Context, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.nc.Publish(s.subject+"."+ev.Type, data)
+}
@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"context"
+	"embed"
+
+	"github.com/derekmwright/web/database/pg"
+)
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const (
+	MigrationsDir   = "migrations"
+	MigrationsTable = "audit_goose_db_version"
+)
+
+// PGSink stores events in the audit_events table. Apply the schema with
+// pg.Migrate using Migrations, MigrationsDir and MigrationsTable.
+type PGSink struct {
+	db *pg.Database
+}
+
+func NewPGSink(db *pg.Database) (*PGSink, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	return &PGSink{db: db}, nil
+}
+
+func (s *PGSink) Record(ctx context.Context, ev Event) error {
+	// The event is part of the request's history even if the client goes away.
+	_, err := s.db.Pool.Exec(context.WithoutCancel(ctx), `
+		INSERT INTO audit_events (occurred_at, type, outcome, sub, actor, tenant, reason, ip, user_agent, method, path, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		ev.Time, ev.Type, string(ev.Outcome), ev.Sub, ev.Actor, ev.Tenant, ev.Reason,
+		ev.IP, ev.UserAgent, ev.Method, ev.Path, ev.RequestID,
+	)
+	return err
+}
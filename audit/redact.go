@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SensitiveKeys are the attribute keys and query parameters whose values
+// RedactHandler replaces. Matching ignores case.
+var SensitiveKeys = []string{
+	"state", "code", "nonce", "verifier", "code_verifier",
+	"token", "access_token", "refresh_token", "id_token", "id_token_hint", "logout_token",
+	"secret", "client_secret", "password", "authorization", "cookie", "set-cookie",
+}
+
+const redacted = "[REDACTED]"
+
+type redactHandler struct {
+	next   slog.Handler
+	keys   []string
+	inline *regexp.Regexp
+}
+
+// NewRedactHandler wraps h so that values of SensitiveKeys, plus any extra
+// keys, are never written. URL values also have those query parameters
+// redacted, so a logged callback URL does not leak its code and state.
+//
+// Messages, string values and error values are also scanned for key=value
+// and "key":"value" pairs of those keys, bearer credentials and JWTs. Other
+// values, such as structs logged with slog.Any, are written as they are.
+func NewRedactHandler(h slog.Handler, extra ...string) slog.Handler {
+	if rh, ok := h.(*redactHandler); ok && len(extra) == 0 {
+		return rh
+	}
+
+	keys := append(slices.Clone(SensitiveKeys), extra...)
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		keys[i] = strings.ToLower(k)
+		quoted[i] = regexp.QuoteMeta(keys[i])
+	}
+
+	alt := strings.Join(quoted, "|")
+	inline := regexp.MustCompile(`(?i)(\b(?:` + alt + `)=)[^\s&"',;]+` +
+		`|("(?:` + alt + `)"\s*:\s*")[^"]*` +
+		`|(\bBearer\s+)[\w.~+/=-]{8,}` +
+		`|\beyJ[\w-]*\.[\w-]+\.[\w-]*`)
+
+	return &redactHandler{next: h, keys: keys, inline: inline}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.redactText(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = h.redact(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(redactedAttrs), keys: h.keys, inline: h.inline}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name), keys: h.keys, inline: h.inline}
+}
+
+func (h *redactHandler) sensitive(key string) bool {
+	return slices.Contains(h.keys, strings.ToLower(key))
+}
+
+func (h *redactHandler) redact(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	if h.sensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redactedGroup := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redactedGroup[i] = h.redact(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redactedGroup...)}
+	case slog.KindString:
+		return slog.String(a.Key, h.redactText(h.redactURL(a.Value.String())))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, h.redactText(err.Error()))
+		}
+	}
+
+	return a
+}
+
+func (h *redactHandler) redactURL(s string) string {
+	if !strings.Contains(s, "?") || !strings.Contains(s, "=") {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.RawQuery == "" {
+		return s
+	}
+
+	q := u.Query()
+	changed := false
+	for k := range q {
+		if h.sensitive(k) {
+			q.Set(k, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return s
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// redactText replaces sensitive values embedded in free text, such as an
+// error wrapping a provider response.
+func (h *redactHandler) redactText(s string) string {
+	return h.inline.ReplaceAllString(s, "${1}${2}${3}"+redacted)
+}
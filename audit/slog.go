@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+)
+
+type SlogSink struct {
+	log *slog.Logger
+}
+
+// NewSlogSink logs successes at Info and failures and denials at Warn.
+func NewSlogSink(log *slog.Logger) (*SlogSink, error) {
+	if log == nil {
+		return nil, ErrNilLogger
+	}
+	return &SlogSink{log: log}, nil
+}
+
+func (s *SlogSink) Record(ctx context.Context, ev Event) error {
+	level := slog.LevelInfo
+	if ev.Outcome != Success {
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("type", ev.Type),
+		slog.String("outcome", string(ev.Outcome)),
+	}
+	for _, a := range [][2]string{
+		{"sub", ev.Sub},
+		{"actor", ev.Actor},
+		{"tenant", ev.Tenant},
+		{"reason", ev.Reason},
+		{"ip", ev.IP},
+		{"user_agent", ev.UserAgent},
+		{"method", ev.Method},
+		{"path", ev.Path},
+		{"request_id", ev.RequestID},
+	} {
+		if a[1] != "" {
+			attrs = append(attrs, slog.String(a[0], a[1]))
+		}
+	}
+
+	s.log.LogAttrs(ctx, level, "audit", slog.Attr{Key: "audit", Value: slog.GroupValue(attrs...)})
+	return nil
+}
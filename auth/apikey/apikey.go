@@ -0,0 +1,348 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/database/pg"
+)
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const (
+	MigrationsDir   = "migrations"
+	MigrationsTable = "apikey_goose_db_version"
+)
+
+// Audit event types recorded when WithAuditSink is set.
+const (
+	EventCreated  = "apikey.created"
+	EventRevoked  = "apikey.revoked"
+	EventRejected = "apikey.rejected"
+)
+
+// Key is a stored API key. The secret is only returned once, by Create.
+type Key struct {
+	ID uuid.UUID `json:"id"`
+	// Prefix identifies the key in listings and logs, e.g. "key_k3j9x2qa".
+	Prefix     string     `json:"prefix"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NewKey describes a key to create. Owner is the sub the key acts as; a zero
+// ExpiresAt never expires.
+type NewKey struct {
+	Name      string
+	Owner     string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// Store keeps keys in the api_keys table. Only a SHA-256 hash of each key is
+// stored. Apply the schema with
+// pg.Migrate using Migrations, MigrationsDir and MigrationsTable.
+type Store struct {
+	db  *pg.Database
+	cfg config
+}
+
+const columns = `id, lookup, name, owner, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+// lookupEncoding is lowercase base32 without padding, so the lookup ID is
+// safe in any header or log.
+var lookupEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func New(db *pg.Database, opts ...Option) (*Store, error) {
+	cfg := config{
+		log:              slog.Default(),
+		prefix:           "key",
+		lastUsedInterval: time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	if cfg.log == nil {
+		return nil, ErrNilLogger
+	}
+	if !validPrefix(cfg.prefix) {
+		return nil, ErrInvalidPrefix
+	}
+
+	return &Store{db: db, cfg: cfg}, nil
+}
+
+// Create stores a new key and returns it with the full secret, which must be
+// shown to the user now; it cannot be recovered later. r is the request asking
+// for the key; its client details go into the audit event. As with Revoke, the
+// user authenticated on r must be the owner or hold the admin scope, and gets
+// ErrForbidden otherwise. A key cannot carry scopes that user does not hold
+// (ErrScopeNotHeld).
+func (s *Store) Create(r *http.Request, nk NewKey) (Key, string, error) {
+	if nk.Owner == "" {
+		return Key{}, "", ErrOwnerRequired
+	}
+
+	if !s.canManage(r, nk.Owner) {
+		s.record(r, EventCreated, audit.Denied, nk.Owner, ErrForbidden.Error())
+		return Key{}, "", ErrForbidden
+	}
+	if missing := ungranted(r, nk.Scopes); len(missing) > 0 {
+		s.record(r, EventCreated, audit.Denied, nk.Owner, "scopes not held: "+strings.Join(missing, " "))
+		return Key{}, "", ErrScopeNotHeld
+	}
+
+	lookup, secret, err := generate(s.cfg.prefix)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	var expiresAt *time.Time
+	if !nk.ExpiresAt.IsZero() {
+		expiresAt = &nk.ExpiresAt
+	}
+	scopes := nk.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	k, err := scanKey(s.db.Pool.QueryRow(r.Context(), `
+		INSERT INTO api_keys (lookup, hash, name, owner, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+columns,
+		lookup, hash(secret), nk.Name, nk.Owner, scopes, expiresAt,
+	), s.cfg.prefix)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	s.record(r, EventCreated, audit.Success, k.Owner, k.Prefix)
+	return k, secret, nil
+}
+
+// Revoke disables a key immediately. Revoking a revoked key is a no-op. The
+// user authenticated on r must own the key or hold the scope set with
+// WithAdminScope; anyone else gets ErrForbidden.
+func (s *Store) Revoke(r *http.Request, id uuid.UUID) error {
+	var owner, lookup string
+	err := s.db.Pool.QueryRow(r.Context(), `SELECT owner, lookup FROM api_keys WHERE id = $1`, id).Scan(&owner, &lookup)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	prefix := s.cfg.prefix + "_" + lookup
+	if !s.canManage(r, owner) {
+		s.record(r, EventRevoked, audit.Denied, owner, prefix)
+		return ErrForbidden
+	}
+
+	// The owner never changes, so the check above still holds.
+	if _, err = s.db.Pool.Exec(r.Context(), `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	s.record(r, EventRevoked, audit.Success, owner, prefix)
+	return nil
+}
+
+// canManage reports whether the user authenticated on r may manage owner's
+// keys.
+func (s *Store) canManage(r *http.Request, owner string) bool {
+	user, ok := auth0.UserFromContext(r.Context())
+	if !ok {
+		return false
+	}
+	if user.Sub == owner {
+		return true
+	}
+	return s.cfg.adminScope != "" && slices.Contains(auth0.Scopes(user), s.cfg.adminScope)
+}
+
+// ungranted returns the scopes in want that the user authenticated on r does
+// not hold.
+func ungranted(r *http.Request, want []string) []string {
+	user, _ := auth0.UserFromContext(r.Context())
+	held := auth0.Scopes(user)
+
+	var missing []string
+	for _, scope := range want {
+		if !slices.Contains(held, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// List returns the owner's keys, newest first, including revoked and expired
+// ones.
+func (s *Store) List(ctx context.Context, owner string) ([]Key, error) {
+	rows, err := s.db.Pool.Query(ctx, `SELECT `+columns+` FROM api_keys WHERE owner = $1 ORDER BY created_at DESC`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		k, err := scanKey(rows, s.cfg.prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// Authenticate returns the key for secret if it exists, matches, and is
+// neither expired nor revoked. It updates the key's last use.
+func (s *Store) Authenticate(ctx context.Context, secret string) (Key, error) {
+	lookup, ok := parse(s.cfg.prefix, secret)
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+
+	var stored []byte
+	k, err := scanKey(s.db.Pool.QueryRow(ctx, `SELECT `+columns+`, hash FROM api_keys WHERE lookup = $1`, lookup), s.cfg.prefix, &stored)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	if subtle.ConstantTimeCompare(stored, hash(secret)) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+
+	now := time.Now()
+	switch {
+	case k.RevokedAt != nil:
+		return Key{}, ErrKeyRevoked
+	case k.ExpiresAt != nil && now.After(*k.ExpiresAt):
+		return Key{}, ErrKeyExpired
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > s.cfg.lastUsedInterval {
+		// A failed write only loses usage data, never the request.
+		if _, err = s.db.Pool.Exec(ctx, `UPDATE api_keys SET last_used_at = now() WHERE id = $1`, k.ID); err != nil {
+			s.cfg.log.Warn("unable to update api key last use", "key", k.Prefix, "error", err)
+		}
+		k.LastUsedAt = &now
+	}
+
+	return k, nil
+}
+
+// record audits an action on sub's key. When the user on r is someone else,
+// e.g. an admin, they are recorded as the actor.
+func (s *Store) record(r *http.Request, typ string, outcome audit.Outcome, sub, reason string) {
+	if s.cfg.auditSink == nil {
+		return
+	}
+
+	ev := audit.FromRequest(r, typ, outcome)
+	ev.Sub = sub
+	ev.Reason = reason
+	if user, ok := auth0.UserFromContext(r.Context()); ok && user.Sub != sub {
+		ev.Actor = user.Sub
+	}
+	if err := s.cfg.auditSink.Record(r.Context(), ev); err != nil {
+		s.cfg.log.Error("unable to record audit event", "type", typ, "error", err)
+	}
+}
+
+func scanKey(row pgx.Row, prefix string, extra ...any) (Key, error) {
+	var (
+		k      Key
+		lookup string
+	)
+	dest := append([]any{&k.ID, &lookup, &k.Name, &k.Owner, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Key{}, err
+	}
+
+	k.Prefix = prefix + "_" + lookup
+	return k, nil
+}
+
+// generate returns a key of the form <prefix>_<lookup>_<secret>. The lookup
+// ID finds the row; the whole key is hashed, so the lookup alone grants
+// nothing.
+func generate(prefix string) (lookup, key string, err error) {
+	b := make([]byte, 5+32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	lookup = lookupEncoding.EncodeToString(b[:5])
+	return lookup, prefix + "_" + lookup + "_" + base64.RawURLEncoding.EncodeToString(b[5:]), nil
+}
+
+// parse returns the lookup ID of a well-formed key with the given prefix.
+func parse(prefix, key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, prefix+"_")
+	if !ok {
+		return "", false
+	}
+
+	lookup, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(lookup) != 8 || secret == "" {
+		return "", false
+	}
+	if _, err := lookupEncoding.DecodeString(lookup); err != nil {
+		return "", false
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(secret); err != nil || len(b) != 32 {
+		return "", false
+	}
+
+	return lookup, true
+}
+
+func hash(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func validPrefix(p string) bool {
+	if p == "" {
+		return false
+	}
+	for _, r := range p {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,265 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/database/pg"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err != ErrNilDatabase {
+		t.Errorf("err = %v, want %v", err, ErrNilDatabase)
+	}
+}
+
+func TestParse(t *testing.T) {
+	lookup, key, err := generate("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "acme_"+lookup+"_") {
+		t.Fatalf("key %q does not start with prefix and lookup %q", key, lookup)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"generated", key, true},
+		{"other prefix", strings.Replace(key, "acme_", "key_", 1), false},
+		{"short lookup", "acme_abc_" + key[len("acme_")+9:], false},
+		{"truncated secret", key[:len(key)-4], false},
+		{"no secret", "acme_" + lookup + "_", false},
+		{"jwt", "eyJhbGciOiJSUzI1NiJ9.e30.sig", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parse("acme", tt.key)
+			if ok != tt.ok || (ok && got != lookup) {
+				t.Errorf("parse = %q, %v; want %q, %v", got, ok, lookup, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidPrefix(t *testing.T) {
+	for p, want := range map[string]bool{"key": true, "acme2": true, "": false, "Acme": false, "a_b": false} {
+		if got := validPrefix(p); got != want {
+			t.Errorf("validPrefix(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	s := &Store{cfg: config{log: slog.Default(), prefix: "acme", lastUsedInterval: time.Minute}}
+
+	tests := []struct {
+		name       string
+		optional   bool
+		auth       string
+		wantStatus int
+	}{
+		{"missing key", false, "", http.StatusUnauthorized},
+		{"missing key optional", true, "", http.StatusNoContent},
+		{"jwt passes through optional", true, "Bearer eyJhbGciOiJSUzI1NiJ9.e30.sig", http.StatusNoContent},
+		{"jwt rejected", false, "Bearer eyJhbGciOiJSUzI1NiJ9.e30.sig", http.StatusUnauthorized},
+		{"malformed key optional", true, "Bearer acme_nope", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := s.Middleware()
+			if tt.optional {
+				mw = s.OptionalMiddleware()
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rr := httptest.NewRecorder()
+
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	k := Key{ID: uuid.New(), Name: "ci", Owner: "auth0|partner", Scopes: []string{"read:orders", "write:orders"}}
+
+	user, err := sessionUser(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Sub != k.Owner {
+		t.Errorf("sub = %q, want %q", user.Sub, k.Owner)
+	}
+	if got := auth0.Scopes(user); !slices.Equal(got, k.Scopes) {
+		t.Errorf("scopes = %q, want %q", got, k.Scopes)
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	s := &Store{cfg: config{log: slog.Default(), prefix: "acme", adminScope: "admin:keys"}}
+
+	user := func(sub, scope string) *auth0.SessionUser {
+		custom, _ := json.Marshal(map[string]string{"scope": scope})
+		return &auth0.SessionUser{Sub: sub, Custom: custom}
+	}
+
+	tests := []struct {
+		name string
+		user *auth0.SessionUser
+		want bool
+	}{
+		{"owner", user("auth0|alice", ""), true},
+		{"other user", user("auth0|mallory", "read:orders"), false},
+		{"admin", user("auth0|admin", "read:orders admin:keys"), true},
+		{"anonymous", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/keys/1", nil)
+			if tt.user != nil {
+				r = r.WithContext(auth0.ContextWithUser(r.Context(), *tt.user))
+			}
+			if got := s.canManage(r, "auth0|alice"); got != tt.want {
+				t.Errorf("canManage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUngranted(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymous bool
+		scope     string
+		want      []string
+		miss      []string
+	}{
+		{name: "no scopes requested", scope: "", want: nil, miss: nil},
+		{name: "subset", scope: "read:orders write:orders", want: []string{"read:orders"}, miss: nil},
+		{name: "escalation", scope: "read:orders", want: []string{"read:orders", "admin:keys"}, miss: []string{"admin:keys"}},
+		{name: "anonymous", anonymous: true, want: []string{"read:orders"}, miss: []string{"read:orders"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/keys", nil)
+			if !tt.anonymous {
+				custom, _ := json.Marshal(map[string]string{"scope": tt.scope})
+				r = r.WithContext(auth0.ContextWithUser(r.Context(), auth0.SessionUser{Sub: "auth0|alice", Custom: custom}))
+			}
+			if got := ungranted(r, tt.want); !slices.Equal(got, tt.miss) {
+				t.Errorf("ungranted = %q, want %q", got, tt.miss)
+			}
+		})
+	}
+}
+
+// TestRevoke needs a disposable database in TEST_DATABASE_URL.
+func TestRevoke(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := pg.New(pg.WithDSN(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = pg.Migrate(db, Migrations, MigrationsDir, pg.WithMigrationsTable(MigrationsTable)); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []audit.Event
+	s, err := New(db, WithAuditSink(audit.SinkFunc(func(ctx context.Context, ev audit.Event) error {
+		events = append(events, ev)
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := "auth0|" + uuid.NewString()
+	request := func(sub string) *http.Request {
+		r := httptest.NewRequest("POST", "/keys", nil)
+		r.RemoteAddr = "203.0.113.7:51234"
+		r.Header.Set("User-Agent", "test-agent")
+		return r.WithContext(auth0.ContextWithUser(r.Context(), auth0.SessionUser{Sub: sub}))
+	}
+
+	k, _, err := s.Create(request(owner), NewKey{Name: "ci", Owner: owner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = s.Create(request("auth0|mallory"), NewKey{Name: "stolen", Owner: owner}); err != ErrForbidden {
+		t.Errorf("create for other user: err = %v, want %v", err, ErrForbidden)
+	}
+	if _, _, err = s.Create(request(owner), NewKey{Name: "escalated", Owner: owner, Scopes: []string{"admin:keys"}}); err != ErrScopeNotHeld {
+		t.Errorf("create with scope not held: err = %v, want %v", err, ErrScopeNotHeld)
+	}
+
+	if err = s.Revoke(request("auth0|mallory"), k.ID); err != ErrForbidden {
+		t.Errorf("revoke by other user: err = %v, want %v", err, ErrForbidden)
+	}
+	if err = s.Revoke(request(owner), k.ID); err != nil {
+		t.Errorf("revoke by owner: %v", err)
+	}
+	if err = s.Revoke(request(owner), uuid.New()); err != ErrNotFound {
+		t.Errorf("revoke unknown key: err = %v, want %v", err, ErrNotFound)
+	}
+
+	want := []struct {
+		typ     string
+		outcome audit.Outcome
+		actor   string
+	}{
+		{EventCreated, audit.Success, ""},
+		{EventCreated, audit.Denied, "auth0|mallory"},
+		{EventCreated, audit.Denied, ""},
+		{EventRevoked, audit.Denied, "auth0|mallory"},
+		{EventRevoked, audit.Success, ""},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		ev := events[i]
+		if ev.Type != w.typ || ev.Outcome != w.outcome || ev.Sub != owner || ev.Actor != w.actor {
+			t.Errorf("event %d = %+v, want %s %s by %q", i, ev, w.typ, w.outcome, w.actor)
+		}
+		if ev.IP != "203.0.113.7" || ev.UserAgent != "test-agent" {
+			t.Errorf("event %d client = %q %q, want request details", i, ev.IP, ev.UserAgent)
+		}
+	}
+}
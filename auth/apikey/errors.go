@@ -0,0 +1,16 @@
+package apikey
+
+import "errors"
+
+var (
+	ErrNilDatabase   = errors.New("database cannot be nil")
+	ErrNilLogger     = errors.New("logger cannot be nil")
+	ErrInvalidPrefix = errors.New("prefix must be lowercase letters and digits")
+	ErrOwnerRequired = errors.New("owner required")
+	ErrInvalidKey    = errors.New("invalid api key")
+	ErrKeyExpired    = errors.New("api key expired")
+	ErrKeyRevoked    = errors.New("api key revoked")
+	ErrNotFound      = errors.New("api key not found")
+	ErrForbidden     = errors.New("not allowed to manage this api key")
+	ErrScopeNotHeld  = errors.New("cannot grant scopes the caller does not hold")
+)
@@ -0,0 +1,116 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/server"
+)
+
+type keyContextKey struct{}
+
+// Middleware authenticates "Authorization: Bearer <key>" requests. The key's
+// owner becomes the auth0 user, with the key's scopes in the "scope" claim,
+// so auth0.CurrentUser and Authorizer.RequireScope work unchanged. Requests
+// without a key are rejected with 401.
+func (s *Store) Middleware() func(http.Handler) http.Handler {
+	return s.middleware(false)
+}
+
+// OptionalMiddleware is Middleware that passes requests without an API key
+// through untouched, e.g. to the auth0 bearer or session middleware that
+// follows it. Those only accept the key's user on routes using
+// auth0.AcceptAPIKeys. Requests with a bad key are still rejected.
+func (s *Store) OptionalMiddleware() func(http.Handler) http.Handler {
+	return s.middleware(true)
+}
+
+func (s *Store) middleware(optional bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, ok := s.keyFromRequest(r)
+			if !ok {
+				if optional {
+					next.ServeHTTP(w, r)
+					return
+				}
+				s.reject(w, r, "", "api key required")
+				return
+			}
+
+			k, err := s.Authenticate(r.Context(), secret)
+			if err != nil {
+				if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrKeyExpired) || errors.Is(err, ErrKeyRevoked) {
+					s.cfg.log.Warn("api key rejected", "error", err)
+					s.reject(w, r, "invalid_token", err.Error())
+					return
+				}
+				s.cfg.log.Error("unable to authenticate api key", "error", err)
+				server.WriteProblem(w, server.NewProblem(http.StatusInternalServerError, ""))
+				return
+			}
+
+			user, err := sessionUser(k)
+			if err != nil {
+				s.cfg.log.Error("unable to encode api key claims", "key", k.Prefix, "error", err)
+				server.WriteProblem(w, server.NewProblem(http.StatusInternalServerError, ""))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), keyContextKey{}, k)
+			next.ServeHTTP(w, r.WithContext(auth0.ContextWithAPIKeyUser(ctx, user)))
+		})
+	}
+}
+
+// keyFromRequest returns the bearer credential if it has this store's key
+// prefix, so JWTs are left to the auth0 bearer middleware.
+func (s *Store) keyFromRequest(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, strings.HasPrefix(token, s.cfg.prefix+"_")
+}
+
+func (s *Store) reject(w http.ResponseWriter, r *http.Request, code, desc string) {
+	if s.cfg.auditSink != nil {
+		ev := audit.FromRequest(r, EventRejected, audit.Failure)
+		ev.Reason = desc
+		if err := s.cfg.auditSink.Record(r.Context(), ev); err != nil {
+			s.cfg.log.Error("unable to record audit event", "type", EventRejected, "error", err)
+		}
+	}
+
+	challenge := `Bearer`
+	if code != "" {
+		challenge += ` error="` + code + `", error_description="` + desc + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, desc))
+}
+
+func sessionUser(k Key) (auth0.SessionUser, error) {
+	custom, err := json.Marshal(map[string]any{
+		"scope":      strings.Join(k.Scopes, " "),
+		"api_key_id": k.ID,
+	})
+	if err != nil {
+		return auth0.SessionUser{}, err
+	}
+
+	return auth0.SessionUser{Sub: k.Owner, Name: k.Name, Custom: custom}, nil
+}
+
+// FromContext returns the API key that authenticated the request.
+func FromContext(ctx context.Context) (Key, bool) {
+	k, ok := ctx.Value(keyContextKey{}).(Key)
+	return k, ok
+}
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lookup       TEXT NOT NULL UNIQUE,
+    hash         BYTEA NOT NULL,
+    name         TEXT NOT NULL DEFAULT '',
+    owner        TEXT NOT NULL,
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_owner_idx ON api_keys (owner);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_keys;
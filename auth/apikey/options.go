@@ -0,0 +1,46 @@
+package apikey
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/derekmwright/web/audit"
+)
+
+type Option func(*config)
+
+type config struct {
+	log              *slog.Logger
+	prefix           string
+	lastUsedInterval time.Duration
+	auditSink        audit.Sink
+	adminScope       string
+}
+
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
+
+// WithPrefix sets the prefix of generated keys, e.g. "acme" for keys like
+// "acme_k3j9x2qa_...". Lowercase letters and digits only; the default is
+// "key".
+func WithPrefix(p string) Option {
+	return func(c *config) { c.prefix = p }
+}
+
+// WithLastUsedInterval limits how often last_used_at is written for a busy
+// key. The default is one minute.
+func WithLastUsedInterval(d time.Duration) Option {
+	return func(c *config) { c.lastUsedInterval = d }
+}
+
+// WithAuditSink records key creation, revocation and rejected requests.
+func WithAuditSink(s audit.Sink) Option {
+	return func(c *config) { c.auditSink = s }
+}
+
+// WithAdminScope lets users whose token or key carries scope revoke keys they
+// do not own. Without it only a key's owner can revoke it.
+func WithAdminScope(scope string) Option {
+	return func(c *config) { c.adminScope = scope }
+}
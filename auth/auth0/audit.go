@@ -0,0 +1,63 @@
+package auth0
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/derekmwright/web/audit"
+)
+
+// Audit event types recorded by the handlers and middleware.
+const (
+	EventLogin             = "auth.login"
+	EventLoginFailed       = "auth.login_failed"
+	EventLogout            = "auth.logout"
+	EventSessionEnded      = "auth.session_ended"
+	EventBackchannelLogout = "auth.backchannel_logout"
+	EventAccessDenied      = "auth.access_denied"
+	EventBearerRejected    = "auth.bearer_rejected"
+
+	EventImpersonationStarted = "auth.impersonation_started"
+	EventImpersonationStopped = "auth.impersonation_stopped"
+)
+
+// recordAudit sends an event to sink. A failing sink is logged and never
+// affects the request.
+func recordAudit(log *slog.Logger, sink audit.Sink, r *http.Request, typ string, outcome audit.Outcome, sub, reason string) {
+	if sink == nil {
+		return
+	}
+
+	ev := audit.FromRequest(r, typ, outcome)
+	ev.Sub = sub
+	ev.Reason = reason
+	ev.Tenant = tenantID(r.Context())
+	if actor, ok := Actor(r.Context()); ok {
+		ev.Actor = actor.Sub
+	}
+
+	if err := sink.Record(r.Context(), ev); err != nil {
+		log.Error("unable to record audit event", "type", typ, "error", err)
+	}
+}
+
+func (d *deps) audit(r *http.Request, typ string, outcome audit.Outcome, sub, reason string) {
+	recordAudit(d.log, d.auditSink, r, typ, outcome, sub, reason)
+}
+
+// auditAs records an event taken by actor on behalf of sub, for requests where
+// the actor is not yet in the context.
+func (d *deps) auditAs(r *http.Request, typ string, outcome audit.Outcome, sub, actor, reason string) {
+	r = r.WithContext(context.WithValue(r.Context(), actorContextKey{}, SessionUser{Sub: actor}))
+	d.audit(r, typ, outcome, sub, reason)
+}
+
+func (d *deps) loginFailed(r *http.Request, sub, reason string) {
+	d.audit(r, EventLoginFailed, audit.Failure, sub, reason)
+}
+
+// redactLogger keeps state, codes and tokens out of the auth logs.
+func redactLogger(l *slog.Logger) *slog.Logger {
+	return slog.New(audit.NewRedactHandler(l.Handler()))
+}
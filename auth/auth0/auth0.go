@@ -3,18 +3,24 @@ package auth0
 import (
 	"context"
 	"encoding/gob"
-	"fmt"
 	"log/slog"
 	"net/http"
-	"net/url"
+	"net/netip"
+	"slices"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/derekmwright/web/audit"
 	"github.com/derekmwright/web/auth/auth0/authenticator"
 )
 
 func init() {
 	gob.Register(SessionUser{})
+	gob.Register(loginState{})
+	gob.Register(sessionToken{})
+	gob.Register(sessionMeta{})
+	gob.Register(impersonation{})
 }
 
 type SessionManager interface {
@@ -22,23 +28,91 @@ type SessionManager interface {
 	Put(ctx context.Context, key string, value any)
 }
 
+// SessionRenewer is implemented by session managers that can rotate the
+// session ID. The callback rotates it on every login.
+type SessionRenewer interface {
+	RenewToken(ctx context.Context) error
+}
+
+// SessionDestroyer is implemented by session managers that can delete the
+// whole session. Logout destroys it after clearing the auth keys.
+type SessionDestroyer interface {
+	Destroy(ctx context.Context) error
+}
+
 type config struct {
-	Logger         *slog.Logger
-	Sessions       SessionManager
-	postLoginHooks []PostLoginHook
+	Logger             *slog.Logger
+	Sessions           SessionManager
+	Authenticator      *authenticator.Authenticator
+	StateTTL           time.Duration
+	PostLoginRedirect  string
+	PostLogoutRedirect string
+	Audiences          []string
+	ClockSkew          time.Duration
+	BearerOptional     bool
+	RoleClaim          string
+	OfflineAccess      bool
+	RefreshLeeway      time.Duration
+	MaxSessionAge      time.Duration
+	IdleTimeout        time.Duration
+	IDTokenExpiry      bool
+	APIRequest         func(*http.Request) bool
+	SessionIndex       SessionIndex
+	Paths              Paths
+	PathPrefix         string
+	TrustedHosts       []string
+	TrustedProxies     []netip.Prefix
+	TenantKey          TenantKey
+	TenantSource       TenantSource
+	ErrorHandler       ErrorHandler
+	AuditSink          audit.Sink
+	Impersonation      ImpersonationPolicy
+	ImpersonationTTL   time.Duration
+	decodeClaims       claimsDecoder
+	preLoginHooks      []hook[PreLoginHook]
+	postLoginHooks     []hook[PostLoginHook]
+	postLogoutHooks    []hook[PostLogoutHook]
 }
 
 type deps struct {
-	auth           *authenticator.Authenticator
-	logoutBase     *url.URL
-	log            *slog.Logger
-	sessions       SessionManager
-	postLoginHooks []PostLoginHook
+	auth                 *authenticator.Authenticator
+	log                  *slog.Logger
+	sessions             SessionManager
+	stateTTL             time.Duration
+	postLoginRedirect    string
+	postLogoutRedirect   string
+	refreshLeeway        time.Duration
+	maxSessionAge        time.Duration
+	idleTimeout          time.Duration
+	enforceIDTokenExpiry bool
+	isAPIRequest         func(*http.Request) bool
+	sessionIndex         SessionIndex
+	paths                Paths
+	pathPrefix           string
+	trustedHosts         []string
+	trustedProxies       []netip.Prefix
+	tenantKey            TenantKey
+	tenantSource         TenantSource
+	errorHandler         ErrorHandler
+	auditSink            audit.Sink
+	impersonationPolicy  ImpersonationPolicy
+	impersonationTimeout time.Duration
+	decodeClaims         claimsDecoder
+	preLoginHooks        []hook[PreLoginHook]
+	postLoginHooks       []hook[PostLoginHook]
+	postLogoutHooks      []hook[PostLogoutHook]
 }
 
 func New(opts ...Option) (func(chi.Router), Middleware, error) {
 	cfg := config{
-		Logger: slog.Default(),
+		Logger:             slog.Default(),
+		StateTTL:           10 * time.Minute,
+		PostLoginRedirect:  "/",
+		PostLogoutRedirect: "/",
+		RefreshLeeway:      30 * time.Second,
+		APIRequest:         IsAPIRequest,
+		ErrorHandler:       defaultErrorHandler,
+		ImpersonationTTL:   time.Hour,
 	}
 
 	for _, opt := range opts {
@@ -51,23 +125,56 @@ func New(opts ...Option) (func(chi.Router), Middleware, error) {
 	if cfg.Sessions == nil {
 		return nil, nil, ErrNilSessions
 	}
+	if !safeRedirectPath(cfg.PostLoginRedirect) {
+		return nil, nil, ErrInvalidRedirect
+	}
+	if cfg.TenantKey != nil && cfg.TenantSource == nil {
+		return nil, nil, ErrNilTenantSource
+	}
 
-	auth, err := authenticator.New()
-	if err != nil {
-		return nil, nil, err
+	auth := cfg.Authenticator
+	if auth == nil {
+		var err error
+		if auth, err = authenticator.New(); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	logoutURL, err := url.Parse(auth.LogoutURL)
-	if err != nil {
-		return nil, nil, fmt.Errorf("unable to parse logout URL: %w", err)
+	if cfg.OfflineAccess && !slices.Contains(auth.Scopes, "offline_access") {
+		// The authenticator may be shared, e.g. with NewBearer; leave the
+		// caller's copy and scopes untouched.
+		clone := *auth
+		clone.Scopes = append(slices.Clone(auth.Scopes), "offline_access")
+		auth = &clone
 	}
 
 	d := &deps{
-		log:            cfg.Logger,
-		logoutBase:     logoutURL,
-		sessions:       cfg.Sessions,
-		auth:           auth,
-		postLoginHooks: cfg.postLoginHooks,
+		log:                  redactLogger(cfg.Logger),
+		sessions:             cfg.Sessions,
+		stateTTL:             cfg.StateTTL,
+		postLoginRedirect:    cfg.PostLoginRedirect,
+		postLogoutRedirect:   cfg.PostLogoutRedirect,
+		refreshLeeway:        cfg.RefreshLeeway,
+		maxSessionAge:        cfg.MaxSessionAge,
+		idleTimeout:          cfg.IdleTimeout,
+		enforceIDTokenExpiry: cfg.IDTokenExpiry,
+		isAPIRequest:         cfg.APIRequest,
+		sessionIndex:         cfg.SessionIndex,
+		paths:                cfg.Paths.withDefaults(),
+		pathPrefix:           cleanPrefix(cfg.PathPrefix),
+		trustedHosts:         cfg.TrustedHosts,
+		trustedProxies:       cfg.TrustedProxies,
+		tenantKey:            cfg.TenantKey,
+		tenantSource:         cfg.TenantSource,
+		decodeClaims:         cfg.decodeClaims,
+		auth:                 auth,
+		errorHandler:         cfg.ErrorHandler,
+		auditSink:            cfg.AuditSink,
+		impersonationPolicy:  cfg.Impersonation,
+		impersonationTimeout: cfg.ImpersonationTTL,
+		preLoginHooks:        cfg.preLoginHooks,
+		postLoginHooks:       cfg.postLoginHooks,
+		postLogoutHooks:      cfg.postLogoutHooks,
 	}
 
 	mw := func(next http.Handler) http.Handler {
@@ -4,8 +4,9 @@ import (
 	"context"
 	"os"
 
-	"github.com/coreos/go-oidc/v3/oidc"
-	"golang.org/x/oauth2"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/derekmwright/web/auth/oidc"
 )
 
 // Config defines required configuration values for Auth0.
@@ -19,12 +20,12 @@ type Config struct {
 	RedirectURI  string
 }
 
-type Authenticator struct {
-	*oidc.Provider
-	oauth2.Config
-	LogoutURL string
-}
+// Authenticator is the generic OIDC authenticator; New configures it for Auth0.
+type Authenticator = oidc.Authenticator
 
+// New configures the authenticator from the AUTH0_* environment variables.
+// Setting AUTH0_ISSUER points it at a plain OIDC issuer instead of the Auth0
+// tenant, e.g. an oidctest provider for offline development.
 func New() (*Authenticator, error) {
 	cfg := Config{
 		Domain:       os.Getenv("AUTH0_DOMAIN"),
@@ -33,6 +34,15 @@ func New() (*Authenticator, error) {
 		RedirectURI:  os.Getenv("AUTH0_REDIRECT_URI"),
 	}
 
+	if issuer := os.Getenv("AUTH0_ISSUER"); issuer != "" {
+		return oidc.New(context.Background(), oidc.Config{
+			Issuer:       issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURI:  cfg.RedirectURI,
+		})
+	}
+
 	if cfg.Domain == "" {
 		return nil, ErrEmptyDomain
 	}
@@ -49,36 +59,36 @@ func New() (*Authenticator, error) {
 		return nil, ErrEmptyRedirectURI
 	}
 
-	provider, err := oidc.NewProvider(
-		context.Background(),
-		"https://"+cfg.Domain+"/",
-	)
-	if err != nil {
-		return nil, err
-	}
+	return oidc.New(context.Background(), OIDCConfig(cfg))
+}
 
-	return &Authenticator{
-		Provider: provider,
-		Config: oauth2.Config{
-			ClientID:     cfg.ClientID,
-			ClientSecret: cfg.ClientSecret,
-			RedirectURL:  cfg.RedirectURI,
-			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
-			Endpoint:     provider.Endpoint(),
+// OIDCConfig maps an Auth0 configuration onto the generic OIDC provider,
+// applying Auth0's logout endpoint conventions.
+func OIDCConfig(cfg Config) oidc.Config {
+	return oidc.Config{
+		Issuer:       "https://" + cfg.Domain + "/",
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURI:  cfg.RedirectURI,
+		Quirks: oidc.Quirks{
+			LogoutURL:           "https://" + cfg.Domain + "/v2/logout",
+			LogoutRedirectParam: "returnTo",
+			OmitIDTokenHint:     true,
 		},
-		LogoutURL: "https://" + cfg.Domain + "/v2/logout",
-	}, nil
+	}
 }
 
-func (a *Authenticator) VerifyIDToken(ctx context.Context, token *oauth2.Token) (*oidc.IDToken, error) {
-	rawIDToken, ok := token.Extra("id_token").(string)
-	if !ok {
-		return nil, ErrNoIDToken
+// NewProvider discovers the Auth0 tenant from AUTH0_DOMAIN alone. It is enough
+// to verify access tokens in API-only services that never run the login flow.
+func NewProvider() (*gooidc.Provider, error) {
+	if issuer := os.Getenv("AUTH0_ISSUER"); issuer != "" {
+		return gooidc.NewProvider(context.Background(), issuer)
 	}
 
-	oidcConfig := &oidc.Config{
-		ClientID: a.ClientID,
+	domain := os.Getenv("AUTH0_DOMAIN")
+	if domain == "" {
+		return nil, ErrEmptyDomain
 	}
 
-	return a.Verifier(oidcConfig).Verify(ctx, rawIDToken)
+	return gooidc.NewProvider(context.Background(), "https://"+domain+"/")
 }
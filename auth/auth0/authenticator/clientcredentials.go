@@ -0,0 +1,197 @@
+package authenticator
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/sync/singleflight"
+)
+
+// ClientCredentials is an oauth2.TokenSource for machine-to-machine calls. It
+// caches the token in memory until it is about to expire, and concurrent
+// callers share a single request to the token endpoint.
+type ClientCredentials struct {
+	cfg        clientcredentials.Config
+	leeway     time.Duration
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token *oauth2.Token
+	group singleflight.Group
+}
+
+// NewClientCredentials creates a token source for cfg's client. Only Domain,
+// ClientID and ClientSecret are used.
+func NewClientCredentials(cfg Config, opts ...ClientCredentialsOption) (*ClientCredentials, error) {
+	c := clientCredentialsConfig{
+		leeway: time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if c.tokenURL == "" {
+		if cfg.Domain == "" {
+			return nil, ErrEmptyDomain
+		}
+		c.tokenURL = "https://" + cfg.Domain + "/oauth/token"
+	}
+
+	if cfg.ClientID == "" {
+		return nil, ErrEmptyClientID
+	}
+
+	if cfg.ClientSecret == "" {
+		return nil, ErrEmptyClientSecret
+	}
+
+	cc := &ClientCredentials{
+		cfg: clientcredentials.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			TokenURL:     c.tokenURL,
+			Scopes:       c.scopes,
+		},
+		leeway:     c.leeway,
+		httpClient: c.httpClient,
+	}
+	if c.audience != "" {
+		cc.cfg.EndpointParams = map[string][]string{"audience": {c.audience}}
+	}
+
+	return cc, nil
+}
+
+// NewClientCredentialsFromEnv reads the client from the AUTH0_* environment
+// variables. AUTH0_ISSUER, when set, replaces the Auth0 domain: the token
+// endpoint is discovered from the issuer unless WithTokenURL is given.
+func NewClientCredentialsFromEnv(opts ...ClientCredentialsOption) (*ClientCredentials, error) {
+	cfg := Config{
+		Domain:       os.Getenv("AUTH0_DOMAIN"),
+		ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
+		ClientSecret: os.Getenv("AUTH0_CLIENT_SECRET"),
+	}
+
+	if issuer := os.Getenv("AUTH0_ISSUER"); issuer != "" {
+		var c clientCredentialsConfig
+		for _, opt := range opts {
+			opt(&c)
+		}
+
+		if c.tokenURL == "" {
+			ctx := context.Background()
+			if c.httpClient != nil {
+				ctx = gooidc.ClientContext(ctx, c.httpClient)
+			}
+
+			provider, err := gooidc.NewProvider(ctx, issuer)
+			if err != nil {
+				return nil, err
+			}
+			opts = append([]ClientCredentialsOption{WithTokenURL(provider.Endpoint().TokenURL)}, opts...)
+		}
+	}
+
+	return NewClientCredentials(cfg, opts...)
+}
+
+func (c *ClientCredentials) Token() (*oauth2.Token, error) {
+	return c.TokenContext(context.Background())
+}
+
+// TokenContext returns the cached token, fetching a new one if it expires
+// within the leeway. ctx only bounds this caller's wait; the shared fetch is
+// not cancelled with it.
+func (c *ClientCredentials) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+
+	if token != nil && (token.Expiry.IsZero() || time.Until(token.Expiry) > c.leeway) {
+		return token, nil
+	}
+
+	ch := c.group.DoChan("token", func() (any, error) {
+		fetchCtx := context.WithoutCancel(ctx)
+		if c.httpClient != nil {
+			fetchCtx = context.WithValue(fetchCtx, oauth2.HTTPClient, c.httpClient)
+		}
+
+		token, err := c.cfg.Token(fetchCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.token = token
+		c.mu.Unlock()
+
+		return token, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*oauth2.Token), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops the cached token so the next call fetches a new one.
+func (c *ClientCredentials) Invalidate() {
+	c.mu.Lock()
+	c.token = nil
+	c.mu.Unlock()
+}
+
+// RoundTripper returns a transport that adds the bearer token to every
+// request before passing it to base, or http.DefaultTransport if base is nil.
+// A 401 response drops the cached token so the next request fetches a fresh
+// one.
+func (c *ClientCredentials) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{source: c, base: base}
+}
+
+// Client returns an HTTP client using RoundTripper.
+func (c *ClientCredentials) Client() *http.Client {
+	return &http.Client{Transport: c.RoundTripper(nil)}
+}
+
+type transport struct {
+	source *ClientCredentials
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.TokenContext(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	token.SetAuthHeader(req)
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.source.Invalidate()
+	}
+
+	return resp, err
+}
@@ -0,0 +1,173 @@
+package authenticator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/derekmwright/web/auth/oidc/oidctest"
+)
+
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientCredentials(t *testing.T) {
+	p, err := oidctest.New(oidctest.WithTokenTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	counter := &countingTransport{}
+	cc, err := NewClientCredentials(
+		Config{ClientID: p.ClientID, ClientSecret: p.ClientSecret},
+		WithTokenURL(p.URL+"/oauth/token"),
+		WithAudience("https://api.example.com"),
+		WithHTTPClient(&http.Client{Transport: counter}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := cc.TokenContext(t.Context()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := counter.n.Load(); n != 1 {
+		t.Errorf("token requests = %d, want 1", n)
+	}
+
+	provider, err := gooidc.NewProvider(t.Context(), p.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotAuth string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		if gotAuth == "Bearer stale" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	resp, err := cc.Client().Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(gotAuth) < len("Bearer ") {
+		t.Fatalf("Authorization = %q, want bearer token", gotAuth)
+	}
+	verifier := provider.Verifier(&gooidc.Config{ClientID: "https://api.example.com"})
+	if _, err = verifier.Verify(t.Context(), gotAuth[len("Bearer "):]); err != nil {
+		t.Errorf("token not valid for audience: %v", err)
+	}
+
+	// A 401 drops the cached token.
+	cc.mu.Lock()
+	cc.token.AccessToken = "stale"
+	cc.mu.Unlock()
+
+	resp, err = cc.Client().Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err = cc.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if n := counter.n.Load(); n != 2 {
+		t.Errorf("token requests after 401 = %d, want 2", n)
+	}
+}
+
+func TestNewClientCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want error
+	}{
+		{"no domain", Config{ClientID: "id", ClientSecret: "secret"}, ErrEmptyDomain},
+		{"no client id", Config{Domain: "example.auth0.com", ClientSecret: "secret"}, ErrEmptyClientID},
+		{"no client secret", Config{Domain: "example.auth0.com", ClientID: "id"}, ErrEmptyClientSecret},
+		{"valid", Config{Domain: "example.auth0.com", ClientID: "id", ClientSecret: "secret"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClientCredentials(tt.cfg); err != tt.want {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCredentialsFromEnvDiscovery(t *testing.T) {
+	p, err := oidctest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// An issuer whose token endpoint is not at {issuer}/oauth/token.
+	var issuer *httptest.Server
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         p.URL + "/oauth/token",
+			"jwks_uri":               p.URL + "/.well-known/jwks.json",
+		})
+	}))
+	defer issuer.Close()
+
+	t.Setenv("AUTH0_DOMAIN", "")
+	t.Setenv("AUTH0_ISSUER", issuer.URL)
+	t.Setenv("AUTH0_CLIENT_ID", p.ClientID)
+	t.Setenv("AUTH0_CLIENT_SECRET", p.ClientSecret)
+
+	cc, err := NewClientCredentialsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.cfg.TokenURL != p.URL+"/oauth/token" {
+		t.Errorf("token URL = %q, want the discovered endpoint", cc.cfg.TokenURL)
+	}
+	if _, err = cc.TokenContext(t.Context()); err != nil {
+		t.Errorf("token from discovered endpoint: %v", err)
+	}
+
+	// An explicit token URL skips discovery.
+	cc, err = NewClientCredentialsFromEnv(WithTokenURL("https://tokens.example.com/token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.cfg.TokenURL != "https://tokens.example.com/token" {
+		t.Errorf("token URL = %q, want the explicit one", cc.cfg.TokenURL)
+	}
+}
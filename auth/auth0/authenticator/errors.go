@@ -1,9 +1,13 @@
 package authenticator
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/derekmwright/web/auth/oidc"
+)
 
 var ErrEmptyDomain = fmt.Errorf("domain cannot be empty")
 var ErrEmptyClientID = fmt.Errorf("client id cannot be empty")
 var ErrEmptyClientSecret = fmt.Errorf("client secret cannot be empty")
 var ErrEmptyRedirectURI = fmt.Errorf("redirect uri cannot be empty")
-var ErrNoIDToken = fmt.Errorf("no id_token field in oauth2 token")
+var ErrNoIDToken = oidc.ErrNoIDToken
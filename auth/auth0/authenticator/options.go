@@ -0,0 +1,43 @@
+package authenticator
+
+import (
+	"net/http"
+	"time"
+)
+
+// ClientCredentialsOption configures NewClientCredentials.
+type ClientCredentialsOption func(*clientCredentialsConfig)
+
+type clientCredentialsConfig struct {
+	audience   string
+	scopes     []string
+	tokenURL   string
+	leeway     time.Duration
+	httpClient *http.Client
+}
+
+// WithAudience sets the API identifier tokens are requested for. Auth0
+// requires it unless the tenant has a default audience.
+func WithAudience(aud string) ClientCredentialsOption {
+	return func(c *clientCredentialsConfig) { c.audience = aud }
+}
+
+func WithScopes(scopes ...string) ClientCredentialsOption {
+	return func(c *clientCredentialsConfig) { c.scopes = scopes }
+}
+
+// WithTokenURL overrides the token endpoint derived from the domain.
+func WithTokenURL(u string) ClientCredentialsOption {
+	return func(c *clientCredentialsConfig) { c.tokenURL = u }
+}
+
+// WithExpiryLeeway sets how long before expiry a cached token is replaced.
+// The default is one minute.
+func WithExpiryLeeway(d time.Duration) ClientCredentialsOption {
+	return func(c *clientCredentialsConfig) { c.leeway = d }
+}
+
+// WithHTTPClient sets the client used to call the token endpoint.
+func WithHTTPClient(hc *http.Client) ClientCredentialsOption {
+	return func(c *clientCredentialsConfig) { c.httpClient = hc }
+}
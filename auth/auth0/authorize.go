@@ -0,0 +1,169 @@
+package auth0
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/server"
+)
+
+// Policy is a custom authorization check, e.g. a lookup of resource ownership
+// in the database. Returning false denies the request with 403; an error
+// responds with 500.
+type Policy func(ctx context.Context, user SessionUser, r *http.Request) (bool, error)
+
+// Authorizer enforces permissions, roles and scopes carried in token claims.
+// Permissions come from Auth0 RBAC's "permissions" claim, scopes from the
+// space-separated "scope" claim, and roles from a configurable (usually
+// namespaced) claim set by an Auth0 Action.
+type Authorizer struct {
+	log       *slog.Logger
+	auditSink audit.Sink
+	roleClaim string
+}
+
+func NewAuthorizer(opts ...Option) (*Authorizer, error) {
+	cfg := config{
+		Logger:    slog.Default(),
+		RoleClaim: "roles",
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.Logger == nil {
+		return nil, ErrNilLogger
+	}
+
+	return &Authorizer{log: redactLogger(cfg.Logger), auditSink: cfg.AuditSink, roleClaim: cfg.RoleClaim}, nil
+}
+
+// RequirePermission requires every listed permission.
+func (a *Authorizer) RequirePermission(perms ...string) Middleware {
+	return a.require("permission", perms, true, Permissions)
+}
+
+// RequireAnyPermission requires at least one listed permission.
+func (a *Authorizer) RequireAnyPermission(perms ...string) Middleware {
+	return a.require("permission", perms, false, Permissions)
+}
+
+// RequireRole requires at least one listed role.
+func (a *Authorizer) RequireRole(roles ...string) Middleware {
+	return a.require("role", roles, false, a.Roles)
+}
+
+// RequireAllRoles requires every listed role.
+func (a *Authorizer) RequireAllRoles(roles ...string) Middleware {
+	return a.require("role", roles, true, a.Roles)
+}
+
+// RequireScope requires every listed scope.
+func (a *Authorizer) RequireScope(scopes ...string) Middleware {
+	return a.require("scope", scopes, true, Scopes)
+}
+
+func (a *Authorizer) RequirePolicy(name string, policy Policy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, "authentication required"))
+				return
+			}
+
+			allowed, err := policy(r.Context(), user, r)
+			if err != nil {
+				a.log.Error("authorization policy failed", "policy", name, "sub", user.Sub, "error", err)
+				server.WriteProblem(w, server.NewProblem(http.StatusInternalServerError, ""))
+				return
+			}
+
+			if !allowed {
+				a.log.Info("authorization denied", "policy", name, "sub", user.Sub, "path", r.URL.Path)
+				recordAudit(a.log, a.auditSink, r, EventAccessDenied, audit.Denied, user.Sub, "denied by policy "+name)
+				server.WriteProblem(w, server.NewProblem(http.StatusForbidden, "denied by policy "+name))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Roles returns the user's roles from the configured role claim.
+func (a *Authorizer) Roles(user SessionUser) []string {
+	return claimStrings(user, a.roleClaim)
+}
+
+func Permissions(user SessionUser) []string {
+	return claimStrings(user, "permissions")
+}
+
+func Scopes(user SessionUser) []string {
+	return claimStrings(user, "scope")
+}
+
+func (a *Authorizer) require(kind string, want []string, all bool, have func(SessionUser) []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, "authentication required"))
+				return
+			}
+
+			granted := have(user)
+
+			var missing []string
+			for _, v := range want {
+				if !slices.Contains(granted, v) {
+					missing = append(missing, v)
+				}
+			}
+
+			if len(missing) == 0 || (!all && len(missing) < len(want)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			detail := "missing " + kind + " " + strings.Join(missing, ", ")
+			if !all {
+				detail = "requires one of " + kind + " " + strings.Join(want, ", ")
+			}
+
+			a.log.Info("authorization denied", "sub", user.Sub, "path", r.URL.Path, "reason", detail)
+			recordAudit(a.log, a.auditSink, r, EventAccessDenied, audit.Denied, user.Sub, detail)
+			server.WriteProblem(w, server.NewProblem(http.StatusForbidden, detail))
+		})
+	}
+}
+
+// claimStrings reads a custom claim holding either a list of strings or a
+// space-separated string.
+func claimStrings(user SessionUser, name string) []string {
+	claims, err := user.CustomClaims()
+	if err != nil || claims == nil {
+		return nil
+	}
+
+	switch v := claims[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package auth0
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/derekmwright/web/audit"
+)
+
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenMaxAge bounds how old a logout token's iat may be.
+const logoutTokenMaxAge = 5 * time.Minute
+
+// HandleBackchannelLogout implements OpenID Connect Back-Channel Logout. The
+// provider POSTs a signed logout_token naming a sub and/or sid, and the
+// matching sessions are revoked in the session index.
+func HandleBackchannelLogout(deps *deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		raw := r.PostFormValue("logout_token")
+		if raw == "" {
+			http.Error(w, "logout_token required", http.StatusBadRequest)
+			return
+		}
+
+		rev, err := verifyLogoutToken(r.Context(), deps, raw)
+		if err != nil {
+			deps.log.Warn("invalid logout token", "error", err)
+			deps.audit(r, EventBackchannelLogout, audit.Failure, "", err.Error())
+			http.Error(w, "invalid logout token", http.StatusBadRequest)
+			return
+		}
+
+		if err = deps.sessionIndex.Revoke(r.Context(), rev); err != nil {
+			deps.log.Error("unable to revoke sessions", "error", err)
+			http.Error(w, "unable to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+
+		deps.log.Info("back-channel logout", "sub", rev.Sub, "sid", rev.SID)
+		deps.audit(r, EventBackchannelLogout, audit.Success, rev.Sub, "")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HandleFrontchannelLogout implements OpenID Connect Front-Channel Logout. The
+// provider loads this URL in an iframe with the browser's cookies, so the
+// request only ends the caller's own session, and only when iss and sid match
+// it. The parameters are unsigned; anyone can craft the URL, so nothing is
+// revoked in the session index.
+func HandleFrontchannelLogout(deps *deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		iss, sid := r.URL.Query().Get("iss"), r.URL.Query().Get("sid")
+		if iss == "" || sid == "" {
+			http.Error(w, "iss and sid required", http.StatusBadRequest)
+			return
+		}
+		if iss != deps.authenticator(r.Context()).Issuer {
+			http.Error(w, "unknown issuer", http.StatusBadRequest)
+			return
+		}
+
+		meta, ok := deps.sessions.Get(r.Context(), SessionMetaKey).(sessionMeta)
+		if ok && meta.SID != "" && meta.SID == sid {
+			user, _ := deps.sessions.Get(r.Context(), "user").(SessionUser)
+			endSession(r.Context(), deps)
+			deps.log.Info("front-channel logout", "sub", user.Sub, "sid", sid)
+			deps.audit(r, EventLogout, audit.Success, user.Sub, "front-channel logout")
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func verifyLogoutToken(ctx context.Context, deps *deps, raw string) (Revocation, error) {
+	// Logout tokens need not carry exp, so expiry is checked via iat instead.
+	auth := deps.authenticator(ctx)
+	token, err := auth.Verifier(&oidc.Config{
+		ClientID:        auth.ClientID,
+		SkipExpiryCheck: true,
+	}).Verify(ctx, raw)
+	if err != nil {
+		return Revocation{}, err
+	}
+
+	var claims struct {
+		SID    string                     `json:"sid"`
+		Nonce  *string                    `json:"nonce"`
+		Events map[string]json.RawMessage `json:"events"`
+	}
+	if err = token.Claims(&claims); err != nil {
+		return Revocation{}, err
+	}
+
+	switch {
+	case claims.Events[backchannelLogoutEvent] == nil:
+		return Revocation{}, ErrInvalidLogoutToken
+	case claims.Nonce != nil:
+		return Revocation{}, ErrInvalidLogoutToken
+	case token.Subject == "" && claims.SID == "":
+		return Revocation{}, ErrInvalidLogoutToken
+	case time.Since(token.IssuedAt) > logoutTokenMaxAge:
+		return Revocation{}, ErrInvalidLogoutToken
+	case !token.Expiry.IsZero() && time.Now().After(token.Expiry):
+		return Revocation{}, ErrInvalidLogoutToken
+	}
+
+	// A token with a sid ends only that session; the session index ignores
+	// sub then, so other sessions of the same user stay signed in.
+	return Revocation{Sub: token.Subject, SID: claims.SID, At: time.Now()}, nil
+}
@@ -0,0 +1,232 @@
+package auth0
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/auth/auth0/authenticator"
+	"github.com/derekmwright/web/server"
+)
+
+// NewBearer returns middleware that authenticates API requests carrying an
+// "Authorization: Bearer <jwt>" access token. Tokens are verified against the
+// provider's JWKS, which is cached and refetched when an unknown key ID
+// appears, so key rotation needs no restart.
+//
+// The resulting user is placed in the same context CurrentUser reads, so
+// chaining NewBearer before the session middleware accepts either form of
+// authentication. Requests without a bearer token are rejected unless
+// WithBearerOptional is set.
+//
+// With WithTenants, the request's tenant is resolved first and tokens are
+// verified against its Authenticator, if it has one, and its Organization.
+func NewBearer(opts ...Option) (Middleware, error) {
+	cfg := config{
+		Logger:    slog.Default(),
+		ClockSkew: time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.Logger == nil {
+		return nil, ErrNilLogger
+	}
+	if len(cfg.Audiences) == 0 {
+		return nil, ErrNoAudience
+	}
+	if cfg.TenantKey != nil && cfg.TenantSource == nil {
+		return nil, ErrNilTenantSource
+	}
+	cfg.Logger = redactLogger(cfg.Logger)
+
+	// Only the tenant fields are used, to share resolveTenant with New.
+	tenants := &deps{log: cfg.Logger, tenantKey: cfg.TenantKey, tenantSource: cfg.TenantSource}
+
+	reject := func(w http.ResponseWriter, r *http.Request, sub, code, desc string) {
+		recordAudit(cfg.Logger, cfg.AuditSink, r, EventBearerRejected, audit.Failure, sub, desc)
+		bearerChallenge(w, code, desc)
+	}
+
+	var provider *oidc.Provider
+	if cfg.Authenticator != nil {
+		provider = cfg.Authenticator.Provider
+	} else {
+		var err error
+		if provider, err = authenticator.NewProvider(); err != nil {
+			return nil, err
+		}
+	}
+
+	verifierConfig := &oidc.Config{
+		// Audience is checked below against any of the configured audiences,
+		// and exp, nbf and iat by checkTokenTimes with the clock skew.
+		SkipClientIDCheck: true,
+		SkipExpiryCheck:   true,
+	}
+	verifier := provider.Verifier(verifierConfig)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := resolveTenant(tenants, w, r)
+			if !ok {
+				return
+			}
+
+			if user, ok := preauthenticated(r.Context()); ok {
+				next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), user)))
+				return
+			}
+
+			raw, ok := bearerToken(r)
+			if !ok {
+				if cfg.BearerOptional {
+					next.ServeHTTP(w, r)
+					return
+				}
+				reject(w, r, "", "", "bearer token required")
+				return
+			}
+
+			v := verifier
+			tenant, _ := TenantFromContext(r.Context())
+			if tenant != nil && tenant.Authenticator != nil {
+				v = tenant.Authenticator.Verifier(verifierConfig)
+			}
+
+			token, err := v.Verify(r.Context(), raw)
+			if err != nil {
+				cfg.Logger.Warn("invalid bearer token", "error", err)
+				reject(w, r, "", "invalid_token", "token is invalid or expired")
+				return
+			}
+
+			var claimsJSON json.RawMessage
+			if err = token.Claims(&claimsJSON); err != nil {
+				cfg.Logger.Error("unable to decode bearer token claims", "error", err)
+				reject(w, r, token.Subject, "invalid_token", "token claims are malformed")
+				return
+			}
+
+			if err = checkTokenTimes(claimsJSON, time.Now(), cfg.ClockSkew); err != nil {
+				cfg.Logger.Warn("invalid bearer token", "error", err)
+				reject(w, r, token.Subject, "invalid_token", "token is invalid or expired")
+				return
+			}
+
+			if !slices.ContainsFunc(token.Audience, func(aud string) bool {
+				return slices.Contains(cfg.Audiences, aud)
+			}) {
+				cfg.Logger.Warn("bearer token audience mismatch", "audience", token.Audience)
+				reject(w, r, token.Subject, "invalid_token", "token audience is not accepted")
+				return
+			}
+
+			user, err := userFromClaims(claimsJSON)
+			if err != nil {
+				cfg.Logger.Warn("unable to decode bearer token claims", "error", err)
+				reject(w, r, token.Subject, "invalid_token", "token claims are malformed")
+				return
+			}
+
+			if tenant != nil && tenant.Organization != "" {
+				var org struct {
+					OrgID string `json:"org_id"`
+				}
+				json.Unmarshal(claimsJSON, &org)
+				if org.OrgID != tenant.Organization {
+					cfg.Logger.Warn("bearer token organization mismatch", "tenant", tenant.ID, "org_id", org.OrgID)
+					reject(w, r, user.Sub, "invalid_token", ErrTenantMismatch.Error())
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), bearerUserContextKey{}, user)
+			ctx = ContextWithUser(ctx, user)
+
+			if cfg.decodeClaims != nil {
+				claims, err := cfg.decodeClaims(claimsJSON)
+				if err != nil {
+					cfg.Logger.Warn("bearer token claims rejected", "sub", user.Sub, "error", err)
+					reject(w, r, user.Sub, "invalid_token", "token claims are not accepted")
+					return
+				}
+				ctx = context.WithValue(ctx, claimsContextKey{}, claims)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, nil
+}
+
+// checkTokenTimes rejects tokens that expired, or are not yet valid, by more
+// than skew in either direction. The exp claim is required.
+func checkTokenTimes(raw json.RawMessage, now time.Time, skew time.Duration) error {
+	var claims struct {
+		Exp *json.Number `json:"exp"`
+		Nbf *json.Number `json:"nbf"`
+		Iat *json.Number `json:"iat"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return err
+	}
+
+	unix := func(n *json.Number) (time.Time, error) {
+		f, err := n.Float64()
+		return time.Unix(int64(f), 0), err
+	}
+
+	if claims.Exp == nil {
+		return ErrTokenExpired
+	}
+	exp, err := unix(claims.Exp)
+	if err != nil {
+		return err
+	}
+	if now.After(exp.Add(skew)) {
+		return ErrTokenExpired
+	}
+
+	for _, n := range []*json.Number{claims.Nbf, claims.Iat} {
+		if n == nil {
+			continue
+		}
+		t, err := unix(n)
+		if err != nil {
+			return err
+		}
+		if now.Add(skew).Before(t) {
+			return ErrTokenNotYetValid
+		}
+	}
+
+	return nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func bearerChallenge(w http.ResponseWriter, code, desc string) {
+	challenge := `Bearer`
+	if code != "" {
+		challenge += ` error="` + code + `", error_description="` + desc + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, desc))
+}
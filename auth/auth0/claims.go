@@ -0,0 +1,63 @@
+package auth0
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// ClaimsKey is the session key holding the claims decoded by WithClaims.
+const ClaimsKey = "claims"
+
+// ClaimsValidator is implemented by claims types that check their own
+// contents. Validate runs once when the claims are decoded; an error rejects
+// the login or bearer token.
+type ClaimsValidator interface {
+	Validate() error
+}
+
+type claimsContextKey struct{}
+
+// claimsDecoder turns the raw JSON claims of a token into the claims type
+// registered with WithClaims.
+type claimsDecoder func(raw []byte) (any, error)
+
+// WithClaims declares the application's claims type. The ID token claims are
+// decoded into T once at callback, validated if T implements ClaimsValidator,
+// and stored in the session; bearer tokens are decoded the same way. Handlers
+// read them back with Claims[T].
+func WithClaims[T any]() Option {
+	var zero T
+	gob.Register(zero)
+
+	return func(cfg *config) {
+		cfg.decodeClaims = func(raw []byte) (any, error) {
+			var claims T
+			if err := json.Unmarshal(raw, &claims); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidClaims, err)
+			}
+
+			if v, ok := any(&claims).(ClaimsValidator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrInvalidClaims, err)
+				}
+			}
+
+			return claims, nil
+		}
+	}
+}
+
+// Claims returns the claims declared with WithClaims for the authenticated
+// request. ok is false when there are none or they are not of type T.
+func Claims[T any](ctx context.Context) (T, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(T)
+	return claims, ok
+}
+
+// ContextWithClaims stores claims in ctx, e.g. to fake an authenticated
+// request in tests.
+func ContextWithClaims[T any](ctx context.Context, claims T) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
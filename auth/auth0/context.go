@@ -0,0 +1,129 @@
+package auth0
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+
+	"github.com/nats-io/nats.go"
+)
+
+// UserHeader is the NATS message header carrying the user set by InjectUser.
+const UserHeader = "Auth-User"
+
+// ActorHeader carries the impersonating user, if any, alongside UserHeader.
+const ActorHeader = "Auth-Actor"
+
+// IsAuthenticated reports whether ctx carries an authenticated user.
+func IsAuthenticated(ctx context.Context) bool {
+	_, ok := UserFromContext(ctx)
+	return ok
+}
+
+// FuncMap returns template functions bound to the request context, for use
+// with a cloned template:
+//
+//	tmpl, _ := base.Clone()
+//	tmpl.Funcs(auth0.FuncMap(r.Context())).Execute(w, data)
+//
+// {{ if isAuthenticated }}Hello {{ currentUser.Name }}{{ end }}
+//
+// While impersonating, currentUser is the impersonated user, and
+// isImpersonating and actor identify the admin:
+//
+// {{ if isImpersonating }}Acting as {{ currentUser.Name }} ({{ actor.Name }}){{ end }}
+func FuncMap(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"currentUser": func() SessionUser {
+			user, _ := UserFromContext(ctx)
+			return user
+		},
+		"isAuthenticated": func() bool {
+			return IsAuthenticated(ctx)
+		},
+		"isImpersonating": func() bool {
+			return IsImpersonating(ctx)
+		},
+		"actor": func() SessionUser {
+			actor, _ := Actor(ctx)
+			return actor
+		},
+	}
+}
+
+// InjectUser copies the user in ctx into msg's headers so a worker handling
+// the message can act on their behalf. It is a no-op for anonymous contexts.
+// Only use it on subjects internal to the application; the header is trusted
+// as is by ExtractUser.
+func InjectUser(ctx context.Context, msg *nats.Msg) error {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	data, err := encodeUser(user)
+	if err != nil {
+		return err
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(UserHeader, data)
+
+	if actor, ok := Actor(ctx); ok {
+		if data, err = encodeUser(actor); err != nil {
+			return err
+		}
+		msg.Header.Set(ActorHeader, data)
+	}
+
+	return nil
+}
+
+// ExtractUser returns ctx carrying the user injected into msg by InjectUser,
+// or ctx unchanged when the message has none. Use it at the top of a
+// worker.Handler.
+func ExtractUser(ctx context.Context, msg *nats.Msg) (context.Context, error) {
+	raw := msg.Header.Get(UserHeader)
+	if raw == "" {
+		return ctx, nil
+	}
+
+	user, err := decodeUser(raw)
+	if err != nil {
+		return ctx, err
+	}
+
+	if raw = msg.Header.Get(ActorHeader); raw != "" {
+		actor, err := decodeUser(raw)
+		if err != nil {
+			return ctx, err
+		}
+		ctx = context.WithValue(ctx, actorContextKey{}, actor)
+	}
+
+	return ContextWithUser(ctx, user), nil
+}
+
+// userJSON carries Custom, which SessionUser does not marshal itself.
+type userJSON struct {
+	SessionUser
+	Custom json.RawMessage `json:"custom,omitempty"`
+}
+
+func encodeUser(user SessionUser) (string, error) {
+	data, err := json.Marshal(userJSON{user, user.Custom})
+	return string(data), err
+}
+
+func decodeUser(raw string) (SessionUser, error) {
+	var data userJSON
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return SessionUser{}, err
+	}
+
+	user := data.SessionUser
+	user.Custom = data.Custom
+	return user, nil
+}
@@ -4,3 +4,31 @@ import "errors"
 
 var ErrNilLogger = errors.New("logger cannot be nil")
 var ErrNilSessions = errors.New("sessions cannot be nil")
+
+var ErrStateMissing = errors.New("no login state found in session")
+var ErrStateMismatch = errors.New("login state does not match")
+var ErrStateExpired = errors.New("login state has expired")
+var ErrNonceMismatch = errors.New("id token nonce does not match")
+var ErrInvalidRedirect = errors.New("post login redirect must be a same-origin path")
+var ErrNoAudience = errors.New("at least one audience is required")
+var ErrNoToken = errors.New("no token found in session")
+var ErrReauthRequired = errors.New("session tokens expired, login required")
+var ErrAuthTooOld = errors.New("provider did not re-authenticate the user")
+var ErrInvalidLogoutToken = errors.New("invalid logout token")
+var ErrSubjectRequired = errors.New("subject required")
+var ErrBucketRequired = errors.New("bucket required")
+var ErrInvalidClaims = errors.New("invalid token claims")
+var ErrTokenExpired = errors.New("token has expired")
+var ErrTokenNotYetValid = errors.New("token is not valid yet")
+var ErrUntrustedHost = errors.New("request host is not trusted")
+var ErrUnknownTenant = errors.New("unknown tenant")
+var ErrNilTenantSource = errors.New("tenant source cannot be nil")
+var ErrTenantMismatch = errors.New("login does not belong to this tenant")
+var ErrNoLoginInProgress = errors.New("no login in progress")
+var ErrNotAuthenticated = errors.New("not authenticated")
+var ErrImpersonationDisabled = errors.New("impersonation is not enabled")
+var ErrImpersonationDenied = errors.New("impersonation denied")
+var ErrAlreadyImpersonating = errors.New("already impersonating a user")
+var ErrNotImpersonating = errors.New("not impersonating a user")
+var ErrImpersonateSelf = errors.New("cannot impersonate yourself")
+var ErrImpersonating = errors.New("not available while impersonating")
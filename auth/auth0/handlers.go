@@ -1,18 +1,25 @@
 package auth0
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
-	"net/url"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
+
+	"github.com/derekmwright/web/audit"
 )
 
-const StateKey = "state"
+const (
+	StateKey   = "state"
+	IDTokenKey = "id_token"
+)
 
 func generateRandomState() (string, error) {
 	b := make([]byte, 32)
@@ -26,45 +33,56 @@ func generateRandomState() (string, error) {
 
 func HandleLogin(deps *deps) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		state, err := generateRandomState()
+		_, loginURL, err := beginLogin(deps, r, r.URL.Query().Get(ReturnToParam), 0)
 		if err != nil {
-			deps.log.Error("unable to generate random state", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			loginError(deps, w, r, err)
 			return
 		}
 
-		deps.log.Info("generated state", "state", state)
-
-		deps.sessions.Put(r.Context(), StateKey, state)
-
-		http.Redirect(w, r, deps.auth.AuthCodeURL(state), http.StatusFound)
+		http.Redirect(w, r, loginURL, http.StatusFound)
 	}
 }
 
 func HandleLogout(deps *deps) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		deps.sessions.Put(r.Context(), "user", nil)
-		deps.sessions.Put(r.Context(), StateKey, nil)
+		idToken, _ := deps.sessions.Get(r.Context(), IDTokenKey).(string)
+		user, _ := deps.sessions.Get(r.Context(), "user").(SessionUser)
+
+		returnTo, err := logoutReturnTo(deps, r)
+		if err != nil {
+			deps.log.Warn("logout from untrusted host", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		scheme := "http"
-		if r.TLS != nil {
-			scheme = "https"
+		// Hooks run while the session still exists so that an aborting hook
+		// leaves the user logged in rather than half logged out.
+		for _, h := range deps.postLogoutHooks {
+			if err := runHook(r.Context(), deps, "post-logout", h.hookConfig, func(ctx context.Context) error {
+				return h.fn(ctx, user, r)
+			}); err != nil {
+				deps.errorHandler(w, r, http.StatusInternalServerError, err)
+				return
+			}
 		}
 
-		returnTo := scheme + "://" + r.Host
+		endSession(r.Context(), deps)
+		deps.audit(r, EventLogout, audit.Success, user.Sub, "")
 
-		logout := deps.logoutBase.ResolveReference(&url.URL{})
-		q := logout.Query()
-		q.Add("returnTo", returnTo)
-		q.Add("client_id", deps.auth.ClientID)
-		logout.RawQuery = q.Encode()
+		logoutURL, err := deps.authenticator(r.Context()).LogoutURL(returnTo, idToken)
+		if err != nil {
+			// Without an end_session_endpoint only the local session can be cleared.
+			deps.log.Warn("unable to build provider logout URL", "error", err)
+			http.Redirect(w, r, returnTo, http.StatusFound)
+			return
+		}
 
-		http.Redirect(w, r, logout.String(), http.StatusFound)
+		http.Redirect(w, r, logoutURL, http.StatusFound)
 	}
 }
 
 type Authenticator interface {
-	Exchange(context.Context, string) (*oauth2.Token, error)
+	Exchange(context.Context, string, ...oauth2.AuthCodeOption) (*oauth2.Token, error)
 	VerifyIDToken(context.Context, *oauth2.Token) (*oidc.IDToken, error)
 }
 
@@ -72,72 +90,191 @@ func HandleCallback(deps *deps) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var user SessionUser
 
-		_, ok := deps.sessions.Get(r.Context(), StateKey).(string)
-		if !ok {
-			deps.log.Error("no state found in session")
-			http.Error(w, "no state found in session", http.StatusInternalServerError)
+		if providerErr := r.URL.Query().Get("error"); providerErr != "" {
+			deps.sessions.Put(r.Context(), StateKey, nil)
+			deps.log.Warn("provider returned an error", "error", providerErr, "description", r.URL.Query().Get("error_description"))
+			deps.loginFailed(r, "", "provider error: "+providerErr)
+			http.Error(w, "login failed: "+providerErr, http.StatusUnauthorized)
+			return
+		}
+
+		ls, err := consumeLoginState(deps, r)
+		if err != nil {
+			deps.log.Warn("invalid login state", "error", err)
+			deps.loginFailed(r, "", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		deps.sessions.Put(r.Context(), StateKey, nil)
+		// The tenant is recovered from the login state, since the callback
+		// path may not identify it, and must agree with the request if it does.
+		if deps.tenantKey != nil {
+			tenant, err := lookupTenant(r.Context(), deps, ls.Tenant)
+			if err != nil {
+				deps.log.Warn("unable to resolve tenant", "tenant", ls.Tenant, "error", err)
+				deps.loginFailed(r, "", err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if other, err := lookupTenant(r.Context(), deps, deps.tenantKey(r)); err == nil && other.ID != tenant.ID {
+				deps.log.Warn("callback tenant mismatch", "tenant", tenant.ID, "request_tenant", other.ID)
+				deps.loginFailed(r, "", ErrTenantMismatch.Error())
+				http.Error(w, ErrTenantMismatch.Error(), http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(ContextWithTenant(r.Context(), tenant))
+		}
+		auth := deps.authenticator(r.Context())
+
+		opts := []oauth2.AuthCodeOption{oauth2.VerifierOption(ls.Verifier)}
+		if ls.RedirectURI != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", ls.RedirectURI))
+		}
 
-		token, err := deps.auth.Exchange(r.Context(), r.URL.Query().Get("code"))
+		token, err := auth.Exchange(r.Context(), r.URL.Query().Get("code"), opts...)
 		if err != nil {
 			deps.log.Error("unable to exchange auth code for token", "error", err)
+			deps.loginFailed(r, "", "code exchange failed")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		idToken, err := deps.auth.VerifyIDToken(r.Context(), token)
+		idToken, err := auth.VerifyIDToken(r.Context(), token)
 		if err != nil {
 			deps.log.Error("unable to verify ID token", "error", err)
+			deps.loginFailed(r, "", "invalid ID token")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		var rawClaims map[string]json.RawMessage
-		if err = idToken.Claims(&rawClaims); err != nil {
+		if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(ls.Nonce)) != 1 {
+			deps.log.Warn("ID token nonce mismatch")
+			deps.loginFailed(r, idToken.Subject, ErrNonceMismatch.Error())
+			http.Error(w, ErrNonceMismatch.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var (
+			raw       json.RawMessage
+			rawClaims map[string]json.RawMessage
+		)
+		if err = idToken.Claims(&raw); err == nil {
+			err = json.Unmarshal(raw, &rawClaims)
+		}
+		if err != nil {
 			deps.log.Error("unable to decode ID token claims", "error", err)
+			deps.loginFailed(r, idToken.Subject, "malformed ID token claims")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		if sub, ok := rawClaims["sub"]; ok {
-			json.Unmarshal(sub, &user.Sub)
+		if t, ok := TenantFromContext(r.Context()); ok && t.Organization != "" {
+			var org struct {
+				OrgID string `json:"org_id"`
+			}
+			json.Unmarshal(raw, &org)
+			if org.OrgID != t.Organization {
+				deps.log.Warn("ID token organization mismatch", "tenant", t.ID, "org_id", org.OrgID)
+				deps.loginFailed(r, idToken.Subject, "organization mismatch")
+				http.Error(w, ErrTenantMismatch.Error(), http.StatusUnauthorized)
+				return
+			}
 		}
-		if name, ok := rawClaims["name"]; ok {
-			json.Unmarshal(name, &user.Name)
+
+		if user, err = userFromClaims(raw); err != nil {
+			deps.log.Error("unable to decode ID token claims", "error", err)
+			deps.loginFailed(r, idToken.Subject, "malformed ID token claims")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if email, ok := rawClaims["email"]; ok {
-			json.Unmarshal(email, &user.Email)
+
+		var claims any
+		if deps.decodeClaims != nil {
+			if claims, err = deps.decodeClaims(raw); err != nil {
+				deps.log.Warn("ID token claims rejected", "sub", user.Sub, "error", err)
+				deps.loginFailed(r, user.Sub, ErrInvalidClaims.Error())
+				http.Error(w, ErrInvalidClaims.Error(), http.StatusUnauthorized)
+				return
+			}
 		}
-		if emailVerified, ok := rawClaims["email_verified"]; ok {
-			json.Unmarshal(emailVerified, &user.EmailVerified)
+
+		var authTime time.Time
+		if raw, ok := rawClaims["auth_time"]; ok {
+			var ts float64
+			if json.Unmarshal(raw, &ts) == nil && ts > 0 {
+				authTime = time.Unix(int64(ts), 0)
+			}
+		}
+
+		// A step-up login must prove when the user authenticated.
+		if ls.MaxAge > 0 && (authTime.IsZero() || time.Since(authTime) > ls.MaxAge+time.Minute) {
+			deps.log.Warn("re-authentication was not performed", "auth_time", authTime)
+			deps.loginFailed(r, user.Sub, ErrAuthTooOld.Error())
+			http.Error(w, ErrAuthTooOld.Error(), http.StatusUnauthorized)
+			return
+		}
+		if authTime.IsZero() {
+			authTime = time.Now()
 		}
-		if picture, ok := rawClaims["picture"]; ok {
-			json.Unmarshal(picture, &user.Picture)
+
+		target := deps.postLoginRedirect
+		if ls.ReturnTo != "" {
+			target = ls.ReturnTo
 		}
 
-		customMap := make(map[string]json.RawMessage)
-		for k, v := range rawClaims {
-			if k != "sub" && k != "name" && k != "email" && k != "picture" {
-				customMap[k] = v
+		for _, h := range deps.postLoginHooks {
+			if err = runHook(r.Context(), deps, "post-login", h.hookConfig, func(ctx context.Context) error {
+				// Each attempt works on copies, so changes made by a failed
+				// attempt are neither seen by a retry nor kept.
+				attemptUser, attemptTarget := user, target
+				attemptUser.Custom = bytes.Clone(user.Custom)
+				if err := h.fn(context.WithValue(ctx, redirectContextKey{}, &attemptTarget), &attemptUser, r); err != nil {
+					return err
+				}
+				user, target = attemptUser, attemptTarget
+				return nil
+			}); err != nil {
+				deps.loginFailed(r, user.Sub, err.Error())
+				deps.errorHandler(w, r, http.StatusInternalServerError, err)
+				return
 			}
 		}
 
-		if len(customMap) > 0 {
-			user.Custom, _ = json.Marshal(customMap)
+		// Rotate the session ID on login to prevent session fixation.
+		if renewer, ok := deps.sessions.(SessionRenewer); ok {
+			if err = renewer.RenewToken(r.Context()); err != nil {
+				deps.log.Error("unable to renew session token", "error", err)
+				deps.loginFailed(r, user.Sub, "unable to renew session")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 		}
 
 		deps.sessions.Put(r.Context(), "user", user)
-		deps.sessions.Put(r.Context(), "access_token", token.AccessToken)
+		if claims != nil {
+			deps.sessions.Put(r.Context(), ClaimsKey, claims)
+		}
+		storeToken(r.Context(), deps, token)
+		if rawIDToken, ok := token.Extra("id_token").(string); ok {
+			deps.sessions.Put(r.Context(), IDTokenKey, rawIDToken)
+		}
 
-		for _, hook := range deps.postLoginHooks {
-			if err = hook(r.Context(), &user, r); err != nil {
-				deps.log.Error("error running post login hook", "error", err)
-			}
+		// Step-up logins keep the session's original creation time.
+		meta, ok := deps.sessions.Get(r.Context(), SessionMetaKey).(sessionMeta)
+		if !ok || ls.MaxAge == 0 {
+			meta = sessionMeta{CreatedAt: time.Now()}
+		}
+		meta.LastSeen = time.Now()
+		meta.AuthTime = authTime
+		meta.LoginAt = time.Now()
+		meta.Tenant = ls.Tenant
+		meta.IDTokenExpiry = idToken.Expiry
+		if sid, ok := rawClaims["sid"]; ok {
+			json.Unmarshal(sid, &meta.SID)
 		}
+		deps.sessions.Put(r.Context(), SessionMetaKey, meta)
+		deps.audit(r, EventLogin, audit.Success, user.Sub, "")
 
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, target, http.StatusFound)
 	}
 }
@@ -2,15 +2,31 @@ package auth0
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"golang.org/x/oauth2"
 
+	"github.com/derekmwright/web/audit"
 	"github.com/derekmwright/web/auth/auth0/authenticator"
+	"github.com/derekmwright/web/auth/oidc/oidctest"
+	"github.com/derekmwright/web/internal/testauth"
+	"github.com/derekmwright/web/nats"
+	"github.com/derekmwright/web/session"
 )
 
 type mockSessionManager struct {
@@ -34,6 +50,29 @@ func (m *mockSessionManager) Put(ctx context.Context, key string, value any) {
 	}
 }
 
+// destroyingSessionManager records Destroy calls, like session.Manager.
+type destroyingSessionManager struct {
+	*mockSessionManager
+	destroyed bool
+}
+
+func (m *destroyingSessionManager) Destroy(ctx context.Context) error {
+	m.destroyed = true
+	return nil
+}
+
+// renewingSessionManager records RenewToken calls, for session managers that
+// cannot destroy sessions.
+type renewingSessionManager struct {
+	*mockSessionManager
+	renewed bool
+}
+
+func (m *renewingSessionManager) RenewToken(ctx context.Context) error {
+	m.renewed = true
+	return nil
+}
+
 func TestHandleLogic(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -80,10 +119,1598 @@ func TestHandleLogic(t *testing.T) {
 				t.Errorf("wrong redirect: %s", rr.Header().Get("Location"))
 			}
 
-			state := mockSessions.store[StateKey].(string)
-			if len(state) == 0 {
+			ls, ok := mockSessions.store[StateKey].(loginState)
+			if !ok || len(ls.State) == 0 || len(ls.Nonce) == 0 || len(ls.Verifier) == 0 {
 				t.Fatal("state not stored or empty")
 			}
+
+			loc, _ := url.Parse(rr.Header().Get("Location"))
+			q := loc.Query()
+			if q.Get("state") != ls.State || q.Get("nonce") != ls.Nonce {
+				t.Errorf("state or nonce missing from redirect: %s", loc)
+			}
+			if q.Get("code_challenge") != oauth2.S256ChallengeFromVerifier(ls.Verifier) || q.Get("code_challenge_method") != "S256" {
+				t.Errorf("PKCE challenge missing from redirect: %s", loc)
+			}
+		})
+	}
+}
+
+func TestHandleCallbackState(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     any
+		query      string
+		wantStatus int
+		wantErr    error
+	}{
+		{
+			name:       "missing state",
+			query:      "?state=abc&code=xyz",
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrStateMissing,
+		},
+		{
+			name:       "mismatched state",
+			stored:     loginState{State: "abc", CreatedAt: time.Now()},
+			query:      "?state=other&code=xyz",
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrStateMismatch,
+		},
+		{
+			name:       "empty state parameter",
+			stored:     loginState{State: "abc", CreatedAt: time.Now()},
+			query:      "?code=xyz",
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrStateMismatch,
+		},
+		{
+			name:       "expired state",
+			stored:     loginState{State: "abc", CreatedAt: time.Now().Add(-time.Hour)},
+			query:      "?state=abc&code=xyz",
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrStateExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSessions := &mockSessionManager{store: make(map[string]any)}
+			if tt.stored != nil {
+				mockSessions.store[StateKey] = tt.stored
+			}
+
+			d := &deps{
+				log:      slog.Default(),
+				sessions: mockSessions,
+				stateTTL: 10 * time.Minute,
+			}
+
+			rr := httptest.NewRecorder()
+			HandleCallback(d)(rr, httptest.NewRequest("GET", "/callback"+tt.query, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantErr.Error()) {
+				t.Errorf("body = %q, want %q", rr.Body.String(), tt.wantErr)
+			}
+			if _, ok := mockSessions.store[StateKey]; ok {
+				t.Error("state should be removed after a callback attempt")
+			}
+		})
+	}
+}
+
+func TestSafeRedirectPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/orders/42?tab=items", true},
+		{"/", true},
+		{"", false},
+		{"orders", false},
+		{"https://evil.example.com/", false},
+		{"//evil.example.com/", false},
+		{"/\\evil.example.com", false},
+		{"/\t/evil.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := safeRedirectPath(tt.target); got != tt.want {
+			t.Errorf("safeRedirectPath(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareStoresReturnTo(t *testing.T) {
+	mockSessions := &mockSessionManager{store: make(map[string]any)}
+	d := &deps{
+		log:      slog.Default(),
+		sessions: mockSessions,
+		auth: &authenticator.Authenticator{
+			Config: oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://test.auth0.com/authorize"}},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	authenticatedMiddleware(d, http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/orders/42?tab=items", nil))
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusFound)
+	}
+	if ls := mockSessions.store[StateKey].(loginState); ls.ReturnTo != "/orders/42?tab=items" {
+		t.Errorf("ReturnTo = %q, want original request URI", ls.ReturnTo)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	authz, err := NewAuthorizer(WithRoleClaim("https://example.com/roles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := SessionUser{
+		Sub:    "auth0|1",
+		Custom: []byte(`{"permissions":["orders:read","orders:write"],"scope":"openid read:reports","https://example.com/roles":["support"]}`),
+	}
+
+	tests := []struct {
+		name       string
+		mw         Middleware
+		user       *SessionUser
+		wantStatus int
+	}{
+		{name: "all permissions present", mw: authz.RequirePermission("orders:read", "orders:write"), user: &user, wantStatus: http.StatusOK},
+		{name: "one permission missing", mw: authz.RequirePermission("orders:write", "orders:delete"), user: &user, wantStatus: http.StatusForbidden},
+		{name: "any permission", mw: authz.RequireAnyPermission("orders:delete", "orders:read"), user: &user, wantStatus: http.StatusOK},
+		{name: "namespaced role", mw: authz.RequireRole("admin", "support"), user: &user, wantStatus: http.StatusOK},
+		{name: "all roles", mw: authz.RequireAllRoles("admin", "support"), user: &user, wantStatus: http.StatusForbidden},
+		{name: "scope", mw: authz.RequireScope("read:reports"), user: &user, wantStatus: http.StatusOK},
+		{name: "anonymous", mw: authz.RequireRole("support"), wantStatus: http.StatusUnauthorized},
+		{
+			name: "policy denies",
+			mw: authz.RequirePolicy("owner", func(ctx context.Context, u SessionUser, r *http.Request) (bool, error) {
+				return u.Sub == "auth0|2", nil
+			}),
+			user:       &user,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/orders", nil)
+			if tt.user != nil {
+				req = req.WithContext(ContextWithUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			tt.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+}
+
+func TestAccessTokenRefresh(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("refresh_token") != "good-refresh" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	tests := []struct {
+		name        string
+		stored      sessionToken
+		wantToken   string
+		wantErr     error
+		wantSession bool
+	}{
+		{
+			name:        "valid token is reused",
+			stored:      sessionToken{AccessToken: "current", Expiry: time.Now().Add(time.Hour)},
+			wantToken:   "current",
+			wantSession: true,
+		},
+		{
+			name:        "expired token is refreshed",
+			stored:      sessionToken{AccessToken: "stale", RefreshToken: "good-refresh", Expiry: time.Now().Add(-time.Minute)},
+			wantToken:   "fresh",
+			wantSession: true,
+		},
+		{
+			name:    "failed refresh invalidates session",
+			stored:  sessionToken{AccessToken: "stale", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Minute)},
+			wantErr: ErrReauthRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSessions := &mockSessionManager{store: map[string]any{
+				"user":   SessionUser{Sub: "auth0|1"},
+				TokenKey: tt.stored,
+			}}
+			sessions := &destroyingSessionManager{mockSessionManager: mockSessions}
+			d := &deps{
+				log:           slog.Default(),
+				sessions:      sessions,
+				refreshLeeway: 30 * time.Second,
+				auth: &authenticator.Authenticator{
+					Config: oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL}},
+				},
+			}
+
+			var (
+				got string
+				err error
+			)
+			authenticatedMiddleware(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, err = AccessToken(r.Context())
+			})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.wantToken {
+				t.Errorf("token = %q, want %q", got, tt.wantToken)
+			}
+			if _, ok := mockSessions.store["user"]; ok != tt.wantSession {
+				t.Errorf("session user present = %v, want %v", ok, tt.wantSession)
+			}
+			if sessions.destroyed == tt.wantSession {
+				t.Errorf("session destroyed = %v, want %v", sessions.destroyed, !tt.wantSession)
+			}
+			if tt.wantToken == "fresh" {
+				if st := mockSessions.store[TokenKey].(sessionToken); st.AccessToken != "fresh" || st.RefreshToken != "good-refresh" {
+					t.Errorf("refreshed token not stored: %+v", st)
+				}
+			}
+		})
+	}
+}
+
+func TestOfflineAccessKeepsScopes(t *testing.T) {
+	auth := &authenticator.Authenticator{Config: oauth2.Config{Scopes: []string{"openid", "profile"}}}
+
+	_, _, err := New(WithAuthenticator(auth), WithSessions(&mockSessionManager{store: make(map[string]any)}), WithOfflineAccess())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(auth.Scopes, []string{"openid", "profile"}) {
+		t.Errorf("caller's scopes = %v, want them unchanged", auth.Scopes)
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		sub        string
+		meta       *sessionMeta
+		header     map[string]string
+		wantStatus int
+		wantUser   bool
+	}{
+		{
+			name:       "legacy session starts tracking",
+			wantStatus: http.StatusOK,
+			wantUser:   true,
+		},
+		{
+			name:       "legacy session of revoked user",
+			sub:        "auth0|revoked",
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "active session",
+			meta:       &sessionMeta{CreatedAt: now.Add(-time.Hour), LastSeen: now.Add(-time.Minute)},
+			wantStatus: http.StatusOK,
+			wantUser:   true,
+		},
+		{
+			name:       "idle timeout redirects to login",
+			meta:       &sessionMeta{CreatedAt: now.Add(-time.Hour), LastSeen: now.Add(-31 * time.Minute)},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "absolute lifetime exceeded",
+			meta:       &sessionMeta{CreatedAt: now.Add(-25 * time.Hour), LastSeen: now},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "API request gets 401",
+			meta:       &sessionMeta{CreatedAt: now.Add(-25 * time.Hour), LastSeen: now},
+			header:     map[string]string{"Datastar-Request": "true"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "revoked sid",
+			meta:       &sessionMeta{CreatedAt: now, LastSeen: now, AuthTime: now.Add(-time.Hour), SID: "revoked"},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "login after revocation",
+			meta:       &sessionMeta{CreatedAt: now, LastSeen: now, AuthTime: now, SID: "revoked"},
+			wantStatus: http.StatusOK,
+			wantUser:   true,
+		},
+	}
+
+	index := NewMemorySessionIndex(time.Hour)
+	index.Revoke(t.Context(), Revocation{SID: "revoked", At: now.Add(-time.Minute)})
+	index.Revoke(t.Context(), Revocation{Sub: "auth0|revoked", At: now.Add(-time.Minute)})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := tt.sub
+			if sub == "" {
+				sub = "auth0|1"
+			}
+			mockSessions := &mockSessionManager{store: map[string]any{"user": SessionUser{Sub: sub}}}
+			if tt.meta != nil {
+				mockSessions.store[SessionMetaKey] = *tt.meta
+			}
+			d := &deps{
+				log:           slog.Default(),
+				sessions:      mockSessions,
+				maxSessionAge: 24 * time.Hour,
+				idleTimeout:   30 * time.Minute,
+				isAPIRequest:  IsAPIRequest,
+				sessionIndex:  index,
+				auth: &authenticator.Authenticator{
+					Config: oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://test.auth0.com/authorize"}},
+				},
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			rr := httptest.NewRecorder()
+			authenticatedMiddleware(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if _, ok := mockSessions.store["user"]; ok != tt.wantUser {
+				t.Errorf("session user present = %v, want %v", ok, tt.wantUser)
+			}
+			if meta, ok := mockSessions.store[SessionMetaKey].(sessionMeta); tt.meta == nil && ok && !meta.AuthTime.IsZero() {
+				t.Errorf("legacy session AuthTime = %v, want zero", meta.AuthTime)
+			}
+		})
+	}
+}
+
+func TestRequireRecentLogin(t *testing.T) {
+	mockSessions := &mockSessionManager{store: map[string]any{
+		"user":         SessionUser{Sub: "auth0|1"},
+		SessionMetaKey: sessionMeta{CreatedAt: time.Now(), LastSeen: time.Now(), AuthTime: time.Now().Add(-time.Hour)},
+	}}
+	d := &deps{
+		log:      slog.Default(),
+		sessions: mockSessions,
+		auth: &authenticator.Authenticator{
+			Config: oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://test.auth0.com/authorize"}},
+		},
+	}
+
+	h := authenticatedMiddleware(d, RequireRecentLogin(5*time.Minute)(http.NotFoundHandler()))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/settings/password", nil))
+
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || loc.Query().Get("prompt") != "login" || loc.Query().Get("max_age") != "300" {
+		t.Fatalf("expected step-up redirect, got %d %s", rr.Code, loc)
+	}
+	if ls := mockSessions.store[StateKey].(loginState); ls.MaxAge != 5*time.Minute || ls.ReturnTo != "/settings/password" {
+		t.Errorf("unexpected login state: %+v", ls)
+	}
+}
+
+type testClaims struct {
+	Sub   string   `json:"sub"`
+	OrgID string   `json:"org_id"`
+	Roles []string `json:"roles"`
+}
+
+func (c *testClaims) Validate() error {
+	if c.OrgID == "" {
+		return errors.New("org_id required")
+	}
+	return nil
+}
+
+func TestClaims(t *testing.T) {
+	var cfg config
+	WithClaims[testClaims]()(&cfg)
+
+	tests := []struct {
+		name       string
+		raw        string
+		wantErr    bool
+		wantCustom string
+	}{
+		{
+			name:       "standard claims stay out of custom",
+			raw:        `{"sub":"auth0|1","email":"a@example.com","email_verified":true,"org_id":"org_1","roles":["admin"]}`,
+			wantCustom: `{"org_id":"org_1","roles":["admin"]}`,
+		},
+		{name: "validation failure", raw: `{"sub":"auth0|1","email_verified":true}`, wantErr: true},
+		{name: "malformed standard claim", raw: `{"sub":"auth0|1","email_verified":"yes","org_id":"org_1"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := userFromClaims([]byte(tt.raw))
+			var claims any
+			if err == nil {
+				claims, err = cfg.decodeClaims([]byte(tt.raw))
+			}
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidClaims) {
+					t.Fatalf("err = %v, want ErrInvalidClaims", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(user.Custom) != tt.wantCustom {
+				t.Errorf("Custom = %s, want %s", user.Custom, tt.wantCustom)
+			}
+
+			ctx := context.WithValue(t.Context(), claimsContextKey{}, claims)
+			got, ok := Claims[testClaims](ctx)
+			if !ok || got.OrgID != "org_1" || got.Sub != "auth0|1" {
+				t.Errorf("Claims = %+v, %v", got, ok)
+			}
+		})
+	}
+}
+
+func TestOptional(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    map[string]any
+		wantUser bool
+	}{
+		{name: "anonymous", store: map[string]any{}},
+		{
+			name:     "logged in",
+			store:    map[string]any{"user": SessionUser{Sub: "auth0|1"}, SessionMetaKey: sessionMeta{CreatedAt: time.Now(), LastSeen: time.Now()}},
+			wantUser: true,
+		},
+		{
+			name:  "expired session",
+			store: map[string]any{"user": SessionUser{Sub: "auth0|1"}, SessionMetaKey: sessionMeta{CreatedAt: time.Now().Add(-48 * time.Hour)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &deps{
+				log:           slog.Default(),
+				sessions:      &mockSessionManager{store: tt.store},
+				maxSessionAge: 24 * time.Hour,
+			}
+
+			var gotUser bool
+			h := Optional(authenticatedMiddleware(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser = IsAuthenticated(r.Context())
+			})))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("authenticated = %v, want %v", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	provider, err := oidctest.New(oidctest.WithUser(oidctest.User{
+		Sub:    "oidctest|42",
+		Name:   "Ada",
+		Claims: map[string]any{"org_id": "org_1"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	router := chi.NewRouter()
+	app := httptest.NewServer(router)
+	defer app.Close()
+
+	// The redirect_uri is derived from the trusted host and mounted path, not
+	// taken from the authenticator.
+	auth, err := provider.Authenticator(t.Context(), "http://unused.example/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := session.New(session.NewMemoryStore(), session.WithSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	sink := audit.SinkFunc(func(_ context.Context, ev audit.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev.Type+":"+string(ev.Outcome)+":"+ev.Sub)
+		return nil
+	})
+
+	register, requireAuth, err := New(
+		WithAuthenticator(auth),
+		WithSessions(sessions),
+		WithAuditSink(sink),
+		WithSessionIndex(NewMemorySessionIndex(time.Hour)),
+		WithClaims[testClaims](),
+		WithPathPrefix("/auth"),
+		WithPaths(Paths{Callback: "/oauth/callback"}),
+		WithTrustedHosts(strings.TrimPrefix(app.URL, "http://")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer, err := NewBearer(WithAuthenticator(auth), WithAudience(provider.ClientID), WithAuditSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Use(sessions.LoadAndSave)
+	router.Route("/auth", register)
+	router.With(requireAuth).Get("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := Claims[testClaims](r.Context())
+		w.Write([]byte(CurrentUser(r).Name + " " + claims.OrgID))
+	})
+	router.With(bearer).Get("/api/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CurrentUser(r).Sub))
+	})
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	get := func(c *http.Client, path string) (int, string) {
+		t.Helper()
+		resp, err := c.Get(app.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get(client, "/dashboard"); status != http.StatusOK || body != "Ada org_1" {
+		t.Fatalf("after login: status = %d, body = %q", status, body)
+	}
+
+	if status, body := get(client, "/auth/userinfo"); status != http.StatusOK || !strings.Contains(body, `"org_id":"org_1"`) {
+		t.Errorf("userinfo: status = %d, body = %q", status, body)
+	}
+
+	logoutToken, err := provider.LogoutToken("oidctest|42", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.PostForm(app.URL+"/auth/backchannel-logout", url.Values{"logout_token": {logoutToken}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("back-channel logout status = %d", resp.StatusCode)
+	}
+
+	noRedirect := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	if status, _ := get(noRedirect, "/dashboard"); status != http.StatusFound {
+		t.Errorf("revoked session: status = %d, want %d", status, http.StatusFound)
+	}
+	if status, _ := get(noRedirect, "/auth/userinfo"); status != http.StatusUnauthorized {
+		t.Errorf("anonymous userinfo: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	accessToken, err := provider.AccessToken(oidctest.User{Sub: "oidctest|42", Claims: map[string]any{"org_id": "org_1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", app.URL+"/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "oidctest|42" {
+		t.Errorf("bearer: status = %d, body = %q", resp.StatusCode, body)
+	}
+
+	if status, _ := get(http.DefaultClient, "/api/me"); status != http.StatusUnauthorized {
+		t.Errorf("missing bearer: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"auth.login:success:oidctest|42",
+		"auth.backchannel_logout:success:oidctest|42",
+		"auth.session_ended:success:oidctest|42",
+		"auth.bearer_rejected:failure:",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("audit events = %q, want %q", events, want)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	provider, err := oidctest.New(oidctest.WithUser(oidctest.User{
+		Sub:    "oidctest|7",
+		Name:   "Grace",
+		Claims: map[string]any{"org_id": "org_acme"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	router := chi.NewRouter()
+	app := httptest.NewServer(router)
+	defer app.Close()
+
+	auth, err := provider.Authenticator(t.Context(), app.URL+"/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := session.New(session.NewMemoryStore(), session.WithSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	register, requireAuth, err := New(
+		WithAuthenticator(auth),
+		WithSessions(sessions),
+		WithTenants(PathTenant(), StaticTenants(
+			Tenant{ID: "acme", Organization: "org_acme"},
+			Tenant{ID: "globex", Organization: "org_globex"},
+		)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Use(sessions.LoadAndSave)
+	register(router)
+	router.With(requireAuth).Get("/{tenant}/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ := TenantFromContext(r.Context())
+		w.Write([]byte(tenant.ID + " " + CurrentUser(r).Name))
+	})
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	noRedirect := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	tests := []struct {
+		name       string
+		client     *http.Client
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "login to tenant", client: client, path: "/acme/dashboard", wantStatus: http.StatusOK, wantBody: "acme Grace"},
+		{name: "session is tenant-scoped", client: noRedirect, path: "/globex/dashboard", wantStatus: http.StatusFound},
+		{name: "organization must match", client: client, path: "/globex/dashboard", wantStatus: http.StatusUnauthorized},
+		{name: "unknown tenant", client: client, path: "/initech/dashboard", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get(app.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPostLoginHooks(t *testing.T) {
+	provider, err := oidctest.New(oidctest.WithUser(oidctest.User{Sub: "oidctest|9", Name: "Linus"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	failing := func(n int) PostLoginHook {
+		calls := 0
+		return func(ctx context.Context, user *SessionUser, r *http.Request) error {
+			calls++
+			if user.Name != "Linus" {
+				return errors.New("hook saw changes from a failed attempt")
+			}
+			if calls <= n {
+				user.Name = "partial"
+				SetLoginRedirect(ctx, "/partial")
+				return errors.New("user sync unavailable")
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name       string
+		opt        Option
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "abort by default", opt: WithPostLoginHook(failing(1)), wantStatus: http.StatusInternalServerError},
+		{name: "continue", opt: WithPostLoginHook(failing(1), HookPolicy(HookContinue)), wantStatus: http.StatusOK, wantBody: "/dashboard Linus"},
+		{name: "legacy hooks continue", opt: WithPostLoginHooks(failing(1)), wantStatus: http.StatusOK, wantBody: "/dashboard Linus"},
+		{name: "retry succeeds", opt: WithPostLoginHook(failing(1), HookRetry(2, time.Millisecond)), wantStatus: http.StatusOK, wantBody: "/dashboard Linus"},
+		{
+			name: "timeout cancels hook",
+			opt: WithPostLoginHook(func(ctx context.Context, user *SessionUser, r *http.Request) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}, HookTimeout(10*time.Millisecond)),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "hook mutates user and redirect",
+			opt: WithPostLoginHook(func(ctx context.Context, user *SessionUser, r *http.Request) error {
+				user.Name = "Linus T."
+				return SetLoginRedirect(ctx, "/welcome")
+			}),
+			wantStatus: http.StatusOK,
+			wantBody:   "/welcome Linus T.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			app := httptest.NewServer(router)
+			defer app.Close()
+
+			auth, err := provider.Authenticator(t.Context(), app.URL+"/callback")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessions, err := session.New(session.NewMemoryStore(), session.WithSecure(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			register, requireAuth, err := New(WithAuthenticator(auth), WithSessions(sessions), tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router.Use(sessions.LoadAndSave)
+			register(router)
+			router.With(requireAuth).Get("/*", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.Path + " " + CurrentUser(r).Name))
+			})
+
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				// Stop at the error page rather than looping back to login.
+				if len(via) > 4 {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			}}
+
+			resp, err := client.Get(app.URL + "/dashboard")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPostLogoutHooks(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []HookOption
+		wantStatus    int
+		wantDestroyed bool
+	}{
+		{name: "abort keeps session", wantStatus: http.StatusInternalServerError},
+		{name: "continue logs out", opts: []HookOption{HookPolicy(HookContinue)}, wantStatus: http.StatusFound, wantDestroyed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen SessionUser
+			sessions := &destroyingSessionManager{mockSessionManager: &mockSessionManager{store: map[string]any{
+				"user": SessionUser{Sub: "oidctest|1"},
+			}}}
+			d := &deps{
+				log:                slog.Default(),
+				sessions:           sessions,
+				auth:               &authenticator.Authenticator{},
+				postLogoutRedirect: "/",
+				errorHandler:       defaultErrorHandler,
+				postLogoutHooks: []hook[PostLogoutHook]{newHook(PostLogoutHook(func(ctx context.Context, user SessionUser, r *http.Request) error {
+					seen = user
+					return errors.New("cleanup unavailable")
+				}), tt.opts)},
+			}
+
+			rr := httptest.NewRecorder()
+			HandleLogout(d)(rr, httptest.NewRequest("GET", "/logout", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if seen.Sub != "oidctest|1" {
+				t.Errorf("hook saw user %q, want oidctest|1", seen.Sub)
+			}
+			if sessions.destroyed != tt.wantDestroyed {
+				t.Errorf("destroyed = %v, want %v", sessions.destroyed, tt.wantDestroyed)
+			}
+			if _, ok := sessions.store["user"]; ok == tt.wantDestroyed {
+				t.Errorf("user still in session = %v, want %v", ok, !tt.wantDestroyed)
+			}
+		})
+	}
+}
+
+func TestLogoutReturnTo(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirect     string
+		trustedHosts []string
+		host         string
+		remoteAddr   string
+		proto        string
+		want         string
+		wantStatus   int
+	}{
+		{name: "absolute URL", redirect: "https://www.example.com/bye", host: "evil.example.com", want: "https://www.example.com/bye"},
+		{name: "path without trusted hosts uses redirect URL", redirect: "/bye", host: "evil.example.com", want: "https://app.example.com/bye"},
+		{name: "path on trusted host", redirect: "/bye", trustedHosts: []string{"app.example.com"}, host: "app.example.com", want: "http://app.example.com/bye"},
+		{name: "forwarded proto from trusted proxy", redirect: "/bye", trustedHosts: []string{"app.example.com"}, host: "app.example.com", remoteAddr: "10.1.2.3:4567", proto: "https", want: "https://app.example.com/bye"},
+		{name: "forwarded proto from other peer", redirect: "/bye", trustedHosts: []string{"app.example.com"}, host: "app.example.com", remoteAddr: "203.0.113.7:4567", proto: "https", want: "http://app.example.com/bye"},
+		{name: "untrusted host", redirect: "/bye", trustedHosts: []string{"app.example.com"}, host: "evil.example.com", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := &renewingSessionManager{mockSessionManager: &mockSessionManager{store: map[string]any{
+				"user": SessionUser{Sub: "oidctest|1"},
+			}}}
+			d := &deps{
+				log:                slog.Default(),
+				sessions:           sessions,
+				auth:               &authenticator.Authenticator{Config: oauth2.Config{RedirectURL: "https://app.example.com/callback"}},
+				postLogoutRedirect: tt.redirect,
+				trustedHosts:       tt.trustedHosts,
+				trustedProxies:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				errorHandler:       defaultErrorHandler,
+			}
+
+			req := httptest.NewRequest("GET", "/logout", nil)
+			req.Host = tt.host
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rr := httptest.NewRecorder()
+			HandleLogout(d)(rr, req)
+
+			if tt.wantStatus != 0 {
+				if rr.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+				}
+				if sessions.renewed || sessions.store["user"] == nil {
+					t.Error("refused logout changed the session")
+				}
+				return
+			}
+
+			// Without an end_session_endpoint the handler redirects to returnTo.
+			if got := rr.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+			// Managers without Destroy get a new token, as in endSession.
+			if !sessions.renewed {
+				t.Error("session token not renewed")
+			}
+			if _, ok := sessions.store["user"]; ok {
+				t.Error("user still in session")
+			}
+		})
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	provider, err := oidctest.New(oidctest.WithUser(oidctest.User{Sub: "oidctest|admin", Name: "Alice"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	router := chi.NewRouter()
+	app := httptest.NewServer(router)
+	defer app.Close()
+
+	auth, err := provider.Authenticator(t.Context(), app.URL+"/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := session.New(session.NewMemoryStore(), session.WithSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	sink := audit.SinkFunc(func(_ context.Context, ev audit.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev.Type+":"+string(ev.Outcome)+":"+ev.Sub+":"+ev.Actor)
+		return nil
+	})
+
+	register, requireAuth, err := New(
+		WithAuthenticator(auth),
+		WithSessions(sessions),
+		WithAuditSink(sink),
+		WithImpersonation(func(ctx context.Context, actor, target SessionUser) (bool, error) {
+			return target.Sub != "oidctest|root", nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Use(sessions.LoadAndSave)
+	register(router)
+	router.Group(func(r chi.Router) {
+		r.Use(requireAuth)
+		r.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+			actor, _ := Actor(r.Context())
+			w.Write([]byte(CurrentUser(r).Sub + " " + actor.Sub))
+		})
+		r.Get("/impersonate", func(w http.ResponseWriter, r *http.Request) {
+			target := SessionUser{Sub: r.URL.Query().Get("sub"), Name: "Bob"}
+			if err := StartImpersonation(r, target, "ticket 42"); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			}
+		})
+		r.Get("/stop", func(w http.ResponseWriter, r *http.Request) {
+			if err := StopImpersonation(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		})
+		r.With(DenyWhileImpersonating).Get("/settings", func(w http.ResponseWriter, r *http.Request) {})
+		r.Get("/token", func(w http.ResponseWriter, r *http.Request) {
+			if _, err := AccessToken(r.Context()); errors.Is(err, ErrImpersonating) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			}
+		})
+	})
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(app.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	steps := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/whoami", http.StatusOK, "oidctest|admin "},
+		{"/impersonate?sub=oidctest|admin", http.StatusForbidden, ErrImpersonateSelf.Error()},
+		{"/impersonate?sub=oidctest|root", http.StatusForbidden, ErrImpersonationDenied.Error()},
+		{"/impersonate?sub=oidctest|bob", http.StatusOK, ""},
+		{"/whoami", http.StatusOK, "oidctest|bob oidctest|admin"},
+		{"/impersonate?sub=oidctest|carol", http.StatusForbidden, ErrAlreadyImpersonating.Error()},
+		{"/settings", http.StatusForbidden, ""},
+		{"/token", http.StatusForbidden, ErrImpersonating.Error()},
+		{"/stop", http.StatusOK, ""},
+		{"/whoami", http.StatusOK, "oidctest|admin "},
+		{"/settings", http.StatusOK, ""},
+	}
+
+	for _, s := range steps {
+		status, body := get(s.path)
+		if status != s.wantStatus || (s.wantBody != "" && !strings.Contains(body, s.wantBody)) {
+			t.Errorf("%s: status = %d, body = %q; want %d, %q", s.path, status, body, s.wantStatus, s.wantBody)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"auth.login:success:oidctest|admin:",
+		"auth.impersonation_started:denied:oidctest|root:oidctest|admin",
+		"auth.impersonation_started:success:oidctest|bob:oidctest|admin",
+		"auth.access_denied:denied:oidctest|bob:oidctest|admin",
+		"auth.impersonation_stopped:success:oidctest|bob:oidctest|admin",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("audit events = %q, want %q", events, want)
+	}
+}
+
+func TestPreauthenticated(t *testing.T) {
+	user := SessionUser{Sub: "auth0|key-owner"}
+
+	tests := []struct {
+		name     string
+		wrap     func(http.Handler) http.Handler
+		ctx      func(context.Context) context.Context
+		wantCode int
+	}{
+		{
+			name:     "context user is not trusted",
+			ctx:      func(ctx context.Context) context.Context { return ContextWithUser(ctx, user) },
+			wantCode: http.StatusFound,
+		},
+		{
+			name:     "api key user without opt in",
+			ctx:      func(ctx context.Context) context.Context { return ContextWithAPIKeyUser(ctx, user) },
+			wantCode: http.StatusFound,
+		},
+		{
+			name:     "api key user with opt in",
+			wrap:     AcceptAPIKeys,
+			ctx:      func(ctx context.Context) context.Context { return ContextWithAPIKeyUser(ctx, user) },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "bearer user",
+			ctx:      func(ctx context.Context) context.Context { return context.WithValue(ctx, bearerUserContextKey{}, user) },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "servertest user",
+			ctx:      func(ctx context.Context) context.Context { return testauth.Trust(ContextWithUser(ctx, user)) },
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &deps{
+				log:      slog.Default(),
+				sessions: &mockSessionManager{store: make(map[string]any)},
+				auth: &authenticator.Authenticator{
+					Config: oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://test.auth0.com/authorize"}},
+				},
+			}
+
+			var h http.Handler = authenticatedMiddleware(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if CurrentUser(r).Sub != user.Sub {
+					t.Errorf("user = %q, want %q", CurrentUser(r).Sub, user.Sub)
+				}
+			}))
+			if tt.wrap != nil {
+				h = tt.wrap(h)
+			}
+
+			req := httptest.NewRequest("GET", "/orders", nil)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req.WithContext(tt.ctx(req.Context())))
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestBearer(t *testing.T) {
+	provider, err := oidctest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	auth, err := provider.Authenticator(t.Context(), "http://unused.example/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer, err := NewBearer(WithAuthenticator(auth), WithAudience("https://api.example.com"), WithClockSkew(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := bearer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CurrentUser(r).Sub))
+	}))
+
+	now := time.Now()
+	claims := func(override map[string]any) map[string]any {
+		c := map[string]any{
+			"iss": provider.URL,
+			"sub": "oidctest|1",
+			"aud": "https://api.example.com",
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range override {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	unsigned := func(c map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+		payload, _ := json.Marshal(c)
+		return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	}
+
+	tests := []struct {
+		name     string
+		token    func() (string, error)
+		wantCode int
+	}{
+		{"valid", func() (string, error) { return provider.Sign(claims(nil)) }, http.StatusOK},
+		{"wrong audience", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"aud": "https://other.example.com"}))
+		}, http.StatusUnauthorized},
+		{"wrong issuer", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"iss": "https://evil.example.com"}))
+		}, http.StatusUnauthorized},
+		{"expired", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()}))
+		}, http.StatusUnauthorized},
+		{"expired within skew", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()}))
+		}, http.StatusOK},
+		{"missing exp", func() (string, error) { return provider.Sign(claims(map[string]any{"exp": nil})) }, http.StatusUnauthorized},
+		{"nbf within skew", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"nbf": now.Add(30 * time.Second).Unix(), "iat": now.Add(30 * time.Second).Unix()}))
+		}, http.StatusOK},
+		{"nbf in the future", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"nbf": now.Add(2 * time.Minute).Unix()}))
+		}, http.StatusUnauthorized},
+		{"iat in the future", func() (string, error) {
+			return provider.Sign(claims(map[string]any{"iat": now.Add(2 * time.Minute).Unix()}))
+		}, http.StatusUnauthorized},
+		{"wrong alg", func() (string, error) { return unsigned(claims(nil)), nil }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest("GET", "/api/orders", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", rr.Code, tt.wantCode, rr.Body.String())
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestStepUpRequiresAuthTime(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []oidctest.Option
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "auth_time present", wantStatus: http.StatusOK, wantBody: "changed"},
+		{name: "auth_time missing", opts: []oidctest.Option{oidctest.WithoutAuthTime()}, wantStatus: http.StatusUnauthorized, wantBody: ErrAuthTooOld.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := oidctest.New(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer provider.Close()
+
+			router := chi.NewRouter()
+			app := httptest.NewServer(router)
+			defer app.Close()
+
+			auth, err := provider.Authenticator(t.Context(), app.URL+"/callback")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessions, err := session.New(session.NewMemoryStore(), session.WithSecure(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			register, requireAuth, err := New(WithAuthenticator(auth), WithSessions(sessions))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router.Use(sessions.LoadAndSave)
+			router.Group(register)
+			// A session from before lifetimes were tracked has no auth time,
+			// so the step-up route sends it back to login with max_age.
+			router.Get("/legacy", func(w http.ResponseWriter, r *http.Request) {
+				sessions.Put(r.Context(), "user", SessionUser{Sub: "oidctest|1"})
+			})
+			router.With(requireAuth, RequireRecentLogin(5*time.Minute)).Get("/settings", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("changed"))
+			})
+
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{Jar: jar}
+
+			resp, err := client.Get(app.URL + "/legacy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			resp, err = client.Get(app.URL + "/settings")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("status = %d, body = %q; want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestFrontchannelLogout(t *testing.T) {
+	const issuer = "https://issuer.example.com"
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantCleared bool
+	}{
+		{name: "matching sid", query: "?iss=" + issuer + "&sid=s1", wantStatus: http.StatusOK, wantCleared: true},
+		{name: "other sid", query: "?iss=" + issuer + "&sid=s2", wantStatus: http.StatusOK},
+		{name: "missing sid", query: "?iss=" + issuer, wantStatus: http.StatusBadRequest},
+		{name: "missing iss", query: "?sid=s1", wantStatus: http.StatusBadRequest},
+		{name: "wrong iss", query: "?iss=https://evil.example.com&sid=s1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSessions := &mockSessionManager{store: map[string]any{
+				"user":         SessionUser{Sub: "auth0|1"},
+				SessionMetaKey: sessionMeta{SID: "s1"},
+			}}
+			sessions := &destroyingSessionManager{mockSessionManager: mockSessions}
+			index := NewMemorySessionIndex(time.Hour)
+			d := &deps{
+				log:          slog.Default(),
+				sessions:     sessions,
+				sessionIndex: index,
+				auth:         &authenticator.Authenticator{Issuer: issuer},
+			}
+
+			rr := httptest.NewRecorder()
+			HandleFrontchannelLogout(d)(rr, httptest.NewRequest("GET", "/frontchannel-logout"+tt.query, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if _, ok := mockSessions.store["user"]; ok == tt.wantCleared {
+				t.Errorf("session user present = %v, want %v", ok, !tt.wantCleared)
+			}
+			if sessions.destroyed != tt.wantCleared {
+				t.Errorf("session destroyed = %v, want %v", sessions.destroyed, tt.wantCleared)
+			}
+			for _, sid := range []string{"s1", "s2"} {
+				if index.IsRevoked(t.Context(), "", sid, time.Now().Add(-time.Minute)) {
+					t.Errorf("front-channel logout revoked sid %q", sid)
+				}
+			}
+		})
+	}
+}
+
+func TestNATSSessionIndex(t *testing.T) {
+	nc, shutdown, err := nats.New(nats.WithJetStream(true, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := NewNATSSessionIndex(js, "revocations", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	loginAt := time.Now().Add(-time.Minute)
+	if err = first.Revoke(t.Context(), Revocation{Sub: "auth0|1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.Revoke(t.Context(), Revocation{Sub: "auth0|3", SID: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	// An older revocation must not replace the stored one.
+	if err = first.Revoke(t.Context(), Revocation{Sub: "auth0|1", At: loginAt.Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A replica started later, or after a restart, loads existing revocations.
+	second, err := NewNATSSessionIndex(js, "revocations", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if !second.IsRevoked(t.Context(), "auth0|1", "", loginAt) {
+		t.Error("sub revocation not loaded at startup")
+	}
+	if !second.IsRevoked(t.Context(), "auth0|2", "s1", loginAt) {
+		t.Error("sid revocation not loaded at startup")
+	}
+	if second.IsRevoked(t.Context(), "auth0|3", "s2", loginAt) {
+		t.Error("sid revocation ended another session of the same sub")
+	}
+
+	// Revocations from other replicas are applied as they arrive.
+	if err = second.Revoke(t.Context(), Revocation{Sub: "oidctest|2"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !first.IsRevoked(t.Context(), "oidctest|2", "", loginAt) {
+		if time.Now().After(deadline) {
+			t.Fatal("revocation from another replica not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionIndexSID(t *testing.T) {
+	nc, shutdown, err := nats.New(nats.WithJetStream(true, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsIndex, err := NewNATSSessionIndex(js, "sid_revocations", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer natsIndex.Close()
+
+	indexes := map[string]SessionIndex{
+		"memory": NewMemorySessionIndex(time.Hour),
+		"nats":   natsIndex,
+	}
+
+	for name, index := range indexes {
+		t.Run(name, func(t *testing.T) {
+			loginAt := time.Now().Add(-time.Minute)
+
+			// Two sessions of the same user; the logout token names the first.
+			if err := index.Revoke(t.Context(), Revocation{Sub: "auth0|1", SID: "laptop"}); err != nil {
+				t.Fatal(err)
+			}
+			if !index.IsRevoked(t.Context(), "auth0|1", "laptop", loginAt) {
+				t.Error("named session not revoked")
+			}
+			if index.IsRevoked(t.Context(), "auth0|1", "phone", loginAt) {
+				t.Error("other session of the same sub revoked")
+			}
+
+			// Without a sid every session of the user ends.
+			if err := index.Revoke(t.Context(), Revocation{Sub: "auth0|1"}); err != nil {
+				t.Fatal(err)
+			}
+			if !index.IsRevoked(t.Context(), "auth0|1", "phone", loginAt) {
+				t.Error("sub revocation did not end every session")
+			}
+		})
+	}
+}
+
+func TestRedirectURI(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		paths      Paths
+		host       string
+		remoteAddr string
+		proto      string
+		want       string
+		wantErr    error
+	}{
+		{name: "default paths", host: "app.example.com", want: "http://app.example.com/callback"},
+		{name: "custom path and prefix", prefix: "auth/", paths: Paths{Callback: "/oauth/callback"}, host: "app.example.com", want: "http://app.example.com/auth/oauth/callback"},
+		{name: "untrusted host", host: "evil.example.com", wantErr: ErrUntrustedHost},
+		{name: "forwarded proto from trusted proxy", host: "app.example.com", remoteAddr: "10.1.2.3:4567", proto: "https", want: "https://app.example.com/callback"},
+		{name: "forwarded proto from other peer", host: "app.example.com", remoteAddr: "203.0.113.7:4567", proto: "https", want: "http://app.example.com/callback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &deps{
+				paths:          tt.paths.withDefaults(),
+				pathPrefix:     cleanPrefix(tt.prefix),
+				trustedHosts:   []string{"app.example.com"},
+				trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			}
+
+			req := httptest.NewRequest("GET", "/login", nil)
+			req.Host = tt.host
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			got, err := redirectURI(d, req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("redirect_uri = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerTenants(t *testing.T) {
+	defaultProvider, err := oidctest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer defaultProvider.Close()
+
+	globexProvider, err := oidctest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer globexProvider.Close()
+
+	defaultAuth, err := defaultProvider.Authenticator(t.Context(), "http://unused.example/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globexAuth, err := globexProvider.Authenticator(t.Context(), "http://unused.example/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer, err := NewBearer(
+		WithAuthenticator(defaultAuth),
+		WithAudience(defaultProvider.ClientID, globexProvider.ClientID),
+		WithTenants(PathTenant(), StaticTenants(
+			Tenant{ID: "acme", Organization: "org_acme"},
+			Tenant{ID: "globex", Authenticator: globexAuth},
+		)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := bearer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ := TenantFromContext(r.Context())
+		w.Write([]byte(tenant.ID))
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		provider *oidctest.Provider
+		org      string
+		wantCode int
+	}{
+		{"tenant organization", "/acme/api", defaultProvider, "org_acme", http.StatusOK},
+		{"other organization", "/acme/api", defaultProvider, "org_other", http.StatusUnauthorized},
+		{"tenant authenticator", "/globex/api", globexProvider, "", http.StatusOK},
+		{"default issuer on tenant with own authenticator", "/globex/api", defaultProvider, "", http.StatusUnauthorized},
+		{"unknown tenant", "/initech/api", defaultProvider, "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.provider.AccessToken(oidctest.User{Sub: "oidctest|1", Claims: map[string]any{"org_id": tt.org}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", rr.Code, tt.wantCode, rr.Body.String())
+			}
 		})
 	}
 }
@@ -0,0 +1,153 @@
+package auth0
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// PreLoginHook runs before the browser is sent to the provider. It may add
+// authorization parameters such as login_hint or screen_hint.
+type PreLoginHook func(ctx context.Context, r *http.Request, params url.Values) error
+
+// PostLogoutHook runs when a user logs out, just before the local session is
+// cleared. A hook that aborts leaves the session in place.
+type PostLogoutHook func(ctx context.Context, user SessionUser, r *http.Request) error
+
+// FailurePolicy decides what happens when a hook returns an error or times
+// out.
+type FailurePolicy int
+
+const (
+	// HookAbort stops the login or logout and renders the error handler.
+	HookAbort FailurePolicy = iota
+	// HookContinue logs the error and carries on.
+	HookContinue
+)
+
+type HookOption func(*hookConfig)
+
+type hookConfig struct {
+	policy   FailurePolicy
+	attempts int
+	backoff  time.Duration
+	timeout  time.Duration
+}
+
+type hook[F any] struct {
+	fn F
+	hookConfig
+}
+
+// HookPolicy sets the failure policy. The default is HookAbort.
+func HookPolicy(p FailurePolicy) HookOption {
+	return func(c *hookConfig) { c.policy = p }
+}
+
+// HookRetry runs the hook up to attempts times, waiting backoff between
+// tries, before applying the failure policy. Changes a failed attempt made to
+// the user, redirect or parameters are discarded.
+func HookRetry(attempts int, backoff time.Duration) HookOption {
+	return func(c *hookConfig) {
+		c.attempts = attempts
+		c.backoff = backoff
+	}
+}
+
+// HookTimeout bounds each attempt; the hook's context is cancelled when it
+// expires. The default is 10 seconds.
+func HookTimeout(d time.Duration) HookOption {
+	return func(c *hookConfig) { c.timeout = d }
+}
+
+func newHook[F any](fn F, opts []HookOption) hook[F] {
+	h := hook[F]{fn: fn, hookConfig: hookConfig{attempts: 1, timeout: 10 * time.Second}}
+	for _, opt := range opts {
+		opt(&h.hookConfig)
+	}
+	if h.attempts < 1 {
+		h.attempts = 1
+	}
+	return h
+}
+
+// runHook calls fn under the hook's timeout and retry settings. It returns an
+// error only when the hook failed and its policy is HookAbort.
+func runHook(ctx context.Context, deps *deps, kind string, cfg hookConfig, fn func(context.Context) error) error {
+	var err error
+
+	for attempt := 1; attempt <= cfg.attempts; attempt++ {
+		hookCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		err = fn(hookCtx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		deps.log.Warn("hook failed", "hook", kind, "attempt", attempt, "error", err)
+
+		if attempt < cfg.attempts {
+			select {
+			case <-time.After(cfg.backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	if cfg.policy == HookContinue {
+		deps.log.Error("continuing after hook failure", "hook", kind, "error", err)
+		return nil
+	}
+
+	return &HookError{Hook: kind, Err: err}
+}
+
+// HookError is passed to the error handler when a hook aborts a login or
+// logout.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return e.Hook + " hook failed: " + e.Err.Error()
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+type redirectContextKey struct{}
+
+// SetLoginRedirect changes where the browser is sent after login. It may be
+// called from a PostLoginHook and accepts same-origin paths only.
+func SetLoginRedirect(ctx context.Context, target string) error {
+	p, ok := ctx.Value(redirectContextKey{}).(*string)
+	if !ok {
+		return ErrNoLoginInProgress
+	}
+	if !safeRedirectPath(target) {
+		return ErrInvalidRedirect
+	}
+
+	*p = target
+	return nil
+}
+
+// ErrorHandler renders a failed login or logout, e.g. as an HTML error page.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, status int, err error) {
+	msg := http.StatusText(status)
+
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		msg = "login could not be completed"
+	}
+
+	http.Error(w, msg, status)
+}
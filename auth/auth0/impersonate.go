@@ -0,0 +1,149 @@
+package auth0
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/server"
+)
+
+const ImpersonationKey = "impersonation"
+
+// ImpersonationPolicy decides whether actor may act as target. Returning
+// false denies the request with ErrImpersonationDenied.
+type ImpersonationPolicy func(ctx context.Context, actor, target SessionUser) (bool, error)
+
+// impersonation is stored in the actor's session while they act as target.
+type impersonation struct {
+	Actor     SessionUser
+	Target    SessionUser
+	Reason    string
+	StartedAt time.Time
+}
+
+type actorContextKey struct{}
+
+// StartImpersonation makes the logged-in user act as target until
+// StopImpersonation, logout, or the impersonation timeout. target is usually
+// loaded by the application, e.g. from its users table. The request must be
+// behind the middleware returned by New, and WithImpersonation must allow it.
+func StartImpersonation(r *http.Request, target SessionUser, reason string) error {
+	deps, ok := r.Context().Value(depsContextKey{}).(*deps)
+	if !ok || deps.impersonationPolicy == nil {
+		return ErrImpersonationDisabled
+	}
+
+	actor, ok := deps.sessions.Get(r.Context(), "user").(SessionUser)
+	if !ok {
+		return ErrNotAuthenticated
+	}
+	if _, ok := deps.sessions.Get(r.Context(), ImpersonationKey).(impersonation); ok {
+		return ErrAlreadyImpersonating
+	}
+	if target.Sub == "" {
+		return ErrSubjectRequired
+	}
+	if target.Sub == actor.Sub {
+		return ErrImpersonateSelf
+	}
+
+	allowed, err := deps.impersonationPolicy(r.Context(), actor, target)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		deps.log.Warn("impersonation denied", "actor", actor.Sub, "sub", target.Sub)
+		deps.auditAs(r, EventImpersonationStarted, audit.Denied, target.Sub, actor.Sub, reason)
+		return ErrImpersonationDenied
+	}
+
+	deps.sessions.Put(r.Context(), ImpersonationKey, impersonation{
+		Actor:     actor,
+		Target:    target,
+		Reason:    reason,
+		StartedAt: time.Now(),
+	})
+
+	deps.log.Info("impersonation started", "actor", actor.Sub, "sub", target.Sub)
+	deps.auditAs(r, EventImpersonationStarted, audit.Success, target.Sub, actor.Sub, reason)
+
+	return nil
+}
+
+// StopImpersonation returns the session to the original user.
+func StopImpersonation(r *http.Request) error {
+	deps, ok := r.Context().Value(depsContextKey{}).(*deps)
+	if !ok {
+		return ErrImpersonationDisabled
+	}
+
+	imp, ok := deps.sessions.Get(r.Context(), ImpersonationKey).(impersonation)
+	if !ok {
+		return ErrNotImpersonating
+	}
+
+	endImpersonation(deps, r, imp, "")
+	return nil
+}
+
+func endImpersonation(deps *deps, r *http.Request, imp impersonation, reason string) {
+	deps.sessions.Put(r.Context(), ImpersonationKey, nil)
+	deps.log.Info("impersonation stopped", "actor", imp.Actor.Sub, "sub", imp.Target.Sub, "reason", reason)
+	deps.auditAs(r, EventImpersonationStopped, audit.Success, imp.Target.Sub, imp.Actor.Sub, reason)
+}
+
+// impersonate returns the context for a session whose owner is user. While an
+// impersonation is active the target becomes the context user and the owner
+// is kept as the actor.
+func impersonate(deps *deps, r *http.Request, user SessionUser) (context.Context, bool) {
+	imp, ok := deps.sessions.Get(r.Context(), ImpersonationKey).(impersonation)
+	if !ok {
+		return r.Context(), false
+	}
+
+	// Ignore an impersonation left behind by a previous session owner.
+	if imp.Actor.Sub != user.Sub {
+		deps.sessions.Put(r.Context(), ImpersonationKey, nil)
+		return r.Context(), false
+	}
+
+	if deps.impersonationTimeout > 0 && time.Since(imp.StartedAt) > deps.impersonationTimeout {
+		endImpersonation(deps, r, imp, "impersonation timed out")
+		return r.Context(), false
+	}
+
+	ctx := context.WithValue(r.Context(), actorContextKey{}, imp.Actor)
+	return context.WithValue(ctx, userContextKey{}, imp.Target), true
+}
+
+// Actor returns the user who is impersonating the current user, if any.
+func Actor(ctx context.Context) (SessionUser, bool) {
+	actor, ok := ctx.Value(actorContextKey{}).(SessionUser)
+	return actor, ok
+}
+
+func IsImpersonating(ctx context.Context) bool {
+	_, ok := Actor(ctx)
+	return ok
+}
+
+// DenyWhileImpersonating protects routes an impersonating admin must not use,
+// such as changing credentials or payment details, with a 403.
+func DenyWhileImpersonating(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := Actor(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if deps, ok := r.Context().Value(depsContextKey{}).(*deps); ok {
+			deps.log.Info("route denied while impersonating", "actor", actor.Sub, "path", r.URL.Path)
+			deps.audit(r, EventAccessDenied, audit.Denied, CurrentUser(r).Sub, "not allowed while impersonating")
+		}
+
+		server.WriteProblem(w, server.NewProblem(http.StatusForbidden, "not allowed while impersonating"))
+	})
+}
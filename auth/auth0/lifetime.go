@@ -0,0 +1,160 @@
+package auth0
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/derekmwright/web/server"
+)
+
+const SessionMetaKey = "session_meta"
+
+// sessionMeta tracks the timestamps needed to enforce session lifetimes.
+type sessionMeta struct {
+	CreatedAt     time.Time
+	LastSeen      time.Time
+	AuthTime      time.Time
+	IDTokenExpiry time.Time
+	SID           string
+	// LoginAt is when the callback completed. Unlike AuthTime, which comes
+	// from the provider in whole seconds, it orders logins precisely against
+	// revocations.
+	LoginAt time.Time
+	// Tenant is the ID of the tenant the session was created for.
+	Tenant string
+}
+
+func (m sessionMeta) loginTime() time.Time {
+	if m.LoginAt.IsZero() {
+		return m.AuthTime
+	}
+	return m.LoginAt
+}
+
+// lastSeenResolution limits how often the idle timer is written back to the
+// session store.
+const lastSeenResolution = time.Minute
+
+// checkLifetime reports why the session is no longer valid, or "" if it is.
+// It refreshes LastSeen as a side effect.
+func checkLifetime(ctx context.Context, deps *deps, user SessionUser) string {
+	now := time.Now()
+
+	meta, ok := deps.sessions.Get(ctx, SessionMetaKey).(sessionMeta)
+	if !ok {
+		// Sessions created before lifetimes were tracked start their age and
+		// idle clocks now. Their auth time is unknown and stays zero, so
+		// RequireRecentLogin sends them to login, and any revocation of the
+		// user applies to them.
+		meta = sessionMeta{CreatedAt: now, LastSeen: now}
+	}
+
+	switch {
+	case deps.maxSessionAge > 0 && now.Sub(meta.CreatedAt) > deps.maxSessionAge:
+		return "session exceeded maximum age"
+	case deps.idleTimeout > 0 && now.Sub(meta.LastSeen) > deps.idleTimeout:
+		return "session idle timeout"
+	case deps.enforceIDTokenExpiry && !meta.IDTokenExpiry.IsZero() && now.After(meta.IDTokenExpiry):
+		return "id token expired"
+	case deps.tenantKey != nil && meta.Tenant != tenantID(ctx):
+		return "session belongs to another tenant"
+	case deps.sessionIndex != nil && deps.sessionIndex.IsRevoked(ctx, user.Sub, meta.SID, meta.loginTime()):
+		return "session revoked"
+	}
+
+	if !ok || now.Sub(meta.LastSeen) > lastSeenResolution {
+		meta.LastSeen = now
+		deps.sessions.Put(ctx, SessionMetaKey, meta)
+	}
+
+	return ""
+}
+
+func clearSession(ctx context.Context, deps *deps) {
+	for _, key := range []string{"user", StateKey, IDTokenKey, TokenKey, "access_token", SessionMetaKey, ClaimsKey, ImpersonationKey} {
+		deps.sessions.Put(ctx, key, nil)
+	}
+}
+
+// endSession clears the auth keys, then destroys the session when the manager
+// supports it, or otherwise gives it a new ID, so its cookie cannot be reused.
+func endSession(ctx context.Context, deps *deps) {
+	clearSession(ctx, deps)
+
+	if destroyer, ok := deps.sessions.(SessionDestroyer); ok {
+		if err := destroyer.Destroy(ctx); err != nil {
+			deps.log.Error("unable to destroy session", "error", err)
+		}
+		return
+	}
+	if renewer, ok := deps.sessions.(SessionRenewer); ok {
+		if err := renewer.RenewToken(ctx); err != nil {
+			deps.log.Error("unable to renew session token", "error", err)
+		}
+	}
+}
+
+// RequireRecentLogin forces step-up re-authentication for sensitive routes:
+// users who last authenticated at the provider longer than maxAge ago are sent
+// back to login with prompt=login and max_age, and the callback verifies the
+// new auth_time. It must run after the middleware returned by New.
+func RequireRecentLogin(maxAge time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deps, ok := r.Context().Value(depsContextKey{}).(*deps)
+			if !ok {
+				server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, "recent login required"))
+				return
+			}
+
+			meta, _ := deps.sessions.Get(r.Context(), SessionMetaKey).(sessionMeta)
+			if time.Since(meta.AuthTime) <= maxAge {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			requireLogin(deps, w, r, maxAge)
+		})
+	}
+}
+
+// requireLogin sends the client to the provider, or answers 401 when the
+// request comes from script rather than a browser navigation.
+func requireLogin(deps *deps, w http.ResponseWriter, r *http.Request, maxAge time.Duration) {
+	if deps.isAPIRequest != nil && deps.isAPIRequest(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, "authentication required"))
+		return
+	}
+
+	var returnTo string
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		returnTo = r.URL.RequestURI()
+	}
+
+	_, loginURL, err := beginLogin(deps, r, returnTo, maxAge)
+	if err != nil {
+		loginError(deps, w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+// IsAPIRequest is the default check for requests that should receive 401
+// instead of a login redirect: XHR, Datastar and JSON requests.
+func IsAPIRequest(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Header.Get("Datastar-Request") == "true" {
+		return true
+	}
+
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+func maxAgeParam(d time.Duration) string {
+	return strconv.FormatInt(int64(d.Seconds()), 10)
+}
@@ -3,36 +3,142 @@ package auth0
 import (
 	"context"
 	"net/http"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/internal/testauth"
 )
 
 type userContextKey struct{}
 
+type depsContextKey struct{}
+
+type optionalContextKey struct{}
+
+type bearerUserContextKey struct{}
+
+type apiKeyUserContextKey struct{}
+
+type acceptAPIKeysContextKey struct{}
+
 type Middleware func(http.Handler) http.Handler
 
 func authenticatedMiddleware(deps *deps, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ok := resolveTenant(deps, w, r)
+		if !ok {
+			return
+		}
+
+		if user, ok := preauthenticated(r.Context()); ok {
+			ctx := context.WithValue(ContextWithUser(r.Context(), user), depsContextKey{}, deps)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		_, optional := r.Context().Value(optionalContextKey{}).(bool)
+
 		sessionUser := deps.sessions.Get(r.Context(), "user")
 		if sessionUser == nil {
-			state, err := generateRandomState()
-			if err != nil {
-				deps.log.Error("unable to generate random state", "error", err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			if optional {
+				next.ServeHTTP(w, r.WithContext(anonymous(r.Context(), deps)))
 				return
 			}
+			requireLogin(deps, w, r, 0)
+			return
+		}
 
-			deps.sessions.Put(r.Context(), StateKey, state)
-
-			loginURL := deps.auth.AuthCodeURL(state)
-			http.Redirect(w, r, loginURL, http.StatusFound)
-
+		user, _ := sessionUser.(SessionUser)
+		if reason := checkLifetime(r.Context(), deps, user); reason != "" {
+			deps.log.Info("session expired", "reason", reason)
+			deps.audit(r, EventSessionEnded, audit.Success, user.Sub, reason)
+			clearSession(r.Context(), deps)
+			if optional {
+				next.ServeHTTP(w, r.WithContext(anonymous(r.Context(), deps)))
+				return
+			}
+			requireLogin(deps, w, r, 0)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userContextKey{}, sessionUser)
+		ctx, impersonating := impersonate(deps, r, user)
+		if !impersonating {
+			ctx = context.WithValue(ctx, userContextKey{}, sessionUser)
+			// The stored claims are the actor's and must not apply to the target.
+			if claims := deps.sessions.Get(r.Context(), ClaimsKey); claims != nil {
+				ctx = context.WithValue(ctx, claimsContextKey{}, claims)
+			}
+		}
+		ctx = context.WithValue(ctx, depsContextKey{}, deps)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// preauthenticated returns a user authenticated earlier in the chain: by
+// NewBearer, by an API key on routes that opt in with AcceptAPIKeys, or by
+// servertest. Users placed in the context with ContextWithUser alone are not
+// trusted.
+func preauthenticated(ctx context.Context) (SessionUser, bool) {
+	if user, ok := ctx.Value(bearerUserContextKey{}).(SessionUser); ok {
+		return user, true
+	}
+	if user, ok := ctx.Value(apiKeyUserContextKey{}).(SessionUser); ok {
+		if _, accept := ctx.Value(acceptAPIKeysContextKey{}).(bool); accept {
+			return user, true
+		}
+	}
+	if testauth.Trusted(ctx) {
+		return UserFromContext(ctx)
+	}
+	return SessionUser{}, false
+}
+
+// anonymous drops any untrusted user an earlier middleware placed in ctx.
+func anonymous(ctx context.Context, deps *deps) context.Context {
+	ctx = context.WithValue(ctx, userContextKey{}, nil)
+	return context.WithValue(ctx, depsContextKey{}, deps)
+}
+
+// Optional makes the middleware returned by New (chained after it) load the
+// user when a valid session exists, and otherwise serve the request
+// anonymously instead of redirecting to login:
+//
+//	r.With(auth0.Optional, requireAuth).Get("/", home)
+func Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), optionalContextKey{}, true)))
+	})
+}
+
+// AcceptAPIKeys lets the middleware returned by New or NewBearer (chained
+// after it) accept a user authenticated by an API key, e.g. from
+// apikey.OptionalMiddleware. Without it, API key principals are ignored:
+//
+//	r.With(auth0.AcceptAPIKeys, requireAuth).Get("/orders", listOrders)
+func AcceptAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), acceptAPIKeysContextKey{}, true)))
+	})
+}
+
+// ContextWithAPIKeyUser is ContextWithUser for a user authenticated by an API
+// key. Routes protected by New or NewBearer only accept it with AcceptAPIKeys.
+func ContextWithAPIKeyUser(ctx context.Context, user SessionUser) context.Context {
+	return ContextWithUser(context.WithValue(ctx, apiKeyUserContextKey{}, user), user)
+}
+
+func ContextWithUser(ctx context.Context, user SessionUser) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the authenticated user, if any, without panicking.
+func UserFromContext(ctx context.Context) (SessionUser, bool) {
+	user, ok := ctx.Value(userContextKey{}).(SessionUser)
+	return user, ok
+}
+
+// CurrentUser returns the authenticated user, or the zero SessionUser for
+// anonymous requests. Use UserFromContext to tell the two apart.
 func CurrentUser(r *http.Request) SessionUser {
-	return r.Context().Value(userContextKey{}).(SessionUser)
+	user, _ := UserFromContext(r.Context())
+	return user
 }
@@ -4,6 +4,11 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/auth/oidc"
 )
 
 type Option func(deps *config)
@@ -22,8 +27,224 @@ func WithSessions(s SessionManager) Option {
 	}
 }
 
+// WithPostLoginHooks adds hooks whose errors are logged without affecting
+// the login. Use WithPostLoginHook to choose a failure policy.
 func WithPostLoginHooks(hooks ...PostLoginHook) Option {
 	return func(c *config) {
-		c.postLoginHooks = append(c.postLoginHooks, hooks...)
+		for _, h := range hooks {
+			c.postLoginHooks = append(c.postLoginHooks, newHook(h, []HookOption{HookPolicy(HookContinue)}))
+		}
+	}
+}
+
+// WithPostLoginHook adds a hook that runs after the ID token is verified and
+// before the session is written, so it can modify the user or call
+// SetLoginRedirect. A failing hook aborts the login unless opts say
+// otherwise.
+func WithPostLoginHook(h PostLoginHook, opts ...HookOption) Option {
+	return func(c *config) {
+		c.postLoginHooks = append(c.postLoginHooks, newHook(h, opts))
+	}
+}
+
+func WithPreLoginHook(h PreLoginHook, opts ...HookOption) Option {
+	return func(c *config) {
+		c.preLoginHooks = append(c.preLoginHooks, newHook(h, opts))
+	}
+}
+
+func WithPostLogoutHook(h PostLogoutHook, opts ...HookOption) Option {
+	return func(c *config) {
+		c.postLogoutHooks = append(c.postLogoutHooks, newHook(h, opts))
+	}
+}
+
+// WithErrorHandler renders login and logout failures caused by hooks. The
+// default writes a plain text error.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(c *config) {
+		c.ErrorHandler = h
+	}
+}
+
+// WithAuditSink records login, logout, session and authorization events in
+// sink. It applies to New, NewBearer and NewAuthorizer.
+func WithAuditSink(s audit.Sink) Option {
+	return func(cfg *config) {
+		cfg.AuditSink = s
+	}
+}
+
+// WithImpersonation lets users allowed by policy act as another user with
+// StartImpersonation.
+func WithImpersonation(policy ImpersonationPolicy) Option {
+	return func(cfg *config) {
+		cfg.Impersonation = policy
+	}
+}
+
+// WithImpersonationTimeout ends impersonations after d. The default is one
+// hour; 0 disables the limit.
+func WithImpersonationTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.ImpersonationTTL = d
+	}
+}
+
+// WithAuthenticator uses a generic OIDC provider (Keycloak, Dex, Okta, Entra
+// ID, ...) instead of the Auth0 configuration read from the environment.
+func WithAuthenticator(a *oidc.Authenticator) Option {
+	return func(cfg *config) {
+		cfg.Authenticator = a
+	}
+}
+
+// WithStateTTL bounds how long a login may take between the redirect to the
+// provider and the callback.
+func WithStateTTL(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.StateTTL = d
+	}
+}
+
+// WithPostLoginRedirect sets where users land after login when there is no
+// original destination to restore. It must be a same-origin path.
+func WithPostLoginRedirect(path string) Option {
+	return func(cfg *config) {
+		cfg.PostLoginRedirect = path
+	}
+}
+
+// WithPostLogoutRedirect sets where the provider sends users after logout. A
+// path is resolved like the redirect_uri: against the request host when it is
+// one of WithTrustedHosts, otherwise against the configured redirect URL.
+// Absolute URLs are used as-is. Either must be allowed in the provider's
+// logout URL settings.
+func WithPostLogoutRedirect(target string) Option {
+	return func(cfg *config) {
+		cfg.PostLogoutRedirect = target
+	}
+}
+
+// WithAudience sets the API audiences accepted by NewBearer.
+func WithAudience(audiences ...string) Option {
+	return func(cfg *config) {
+		cfg.Audiences = append(cfg.Audiences, audiences...)
+	}
+}
+
+// WithClockSkew sets the tolerance applied to bearer token exp, nbf and iat,
+// in either direction.
+func WithClockSkew(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.ClockSkew = d
+	}
+}
+
+// WithBearerOptional lets NewBearer pass requests without an Authorization
+// header through, e.g. to the session middleware.
+func WithBearerOptional() Option {
+	return func(cfg *config) {
+		cfg.BearerOptional = true
+	}
+}
+
+// WithRoleClaim sets the claim NewAuthorizer reads roles from, typically a
+// namespaced claim such as "https://example.com/roles".
+func WithRoleClaim(name string) Option {
+	return func(cfg *config) {
+		cfg.RoleClaim = name
+	}
+}
+
+// WithOfflineAccess requests the offline_access scope so the provider issues a
+// refresh token.
+func WithOfflineAccess() Option {
+	return func(cfg *config) {
+		cfg.OfflineAccess = true
+	}
+}
+
+// WithRefreshLeeway sets how long before expiry an access token is refreshed.
+func WithRefreshLeeway(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.RefreshLeeway = d
+	}
+}
+
+// WithSessionLifetime sets the absolute maximum session age and the idle
+// timeout. Zero disables the respective check.
+func WithSessionLifetime(maxAge, idle time.Duration) Option {
+	return func(cfg *config) {
+		cfg.MaxSessionAge = maxAge
+		cfg.IdleTimeout = idle
+	}
+}
+
+// WithIDTokenExpiry ends the session when the ID token obtained at login
+// expires.
+func WithIDTokenExpiry() Option {
+	return func(cfg *config) {
+		cfg.IDTokenExpiry = true
+	}
+}
+
+// WithAPIRequestMatcher decides which unauthenticated requests get a 401
+// response instead of a redirect to login. The default is IsAPIRequest.
+func WithAPIRequestMatcher(fn func(*http.Request) bool) Option {
+	return func(cfg *config) {
+		cfg.APIRequest = fn
+	}
+}
+
+// WithSessionIndex enables back- and front-channel logout and revocation
+// checks against idx on every request.
+func WithSessionIndex(idx SessionIndex) Option {
+	return func(cfg *config) {
+		cfg.SessionIndex = idx
+	}
+}
+
+// WithTenants enables multi-tenancy: each request's tenant is identified by
+// key and looked up in source, and sessions are bound to the tenant they were
+// created for.
+func WithTenants(key TenantKey, source TenantSource) Option {
+	return func(cfg *config) {
+		cfg.TenantKey = key
+		cfg.TenantSource = source
+	}
+}
+
+// WithPaths renames the auth routes. Empty fields keep the defaults.
+func WithPaths(p Paths) Option {
+	return func(cfg *config) {
+		cfg.Paths = p
+	}
+}
+
+// WithPathPrefix tells the module where the auth routes are mounted, e.g.
+// "/auth" for r.Route("/auth", registerRoutes). It is used to build the
+// login and callback URLs.
+func WithPathPrefix(prefix string) Option {
+	return func(cfg *config) {
+		cfg.PathPrefix = prefix
+	}
+}
+
+// WithTrustedHosts derives the redirect_uri from the request's Host header
+// when it is one of hosts, instead of using AUTH0_REDIRECT_URI. Logins from
+// any other host are refused.
+func WithTrustedHosts(hosts ...string) Option {
+	return func(cfg *config) {
+		cfg.TrustedHosts = append(cfg.TrustedHosts, hosts...)
+	}
+}
+
+// WithTrustedProxies honours X-Forwarded-Proto when building the redirect_uri
+// for requests whose peer address is in one of proxies, e.g. a TLS-terminating
+// load balancer. From any other peer the header is ignored.
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(cfg *config) {
+		cfg.TrustedProxies = append(cfg.TrustedProxies, proxies...)
 	}
 }
@@ -0,0 +1,161 @@
+package auth0
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/derekmwright/web/server"
+)
+
+// Paths names the routes added by the register function. Empty fields keep
+// their defaults.
+type Paths struct {
+	Login              string
+	Callback           string
+	Logout             string
+	Userinfo           string
+	BackchannelLogout  string
+	FrontchannelLogout string
+}
+
+var defaultPaths = Paths{
+	Login:              "/login",
+	Callback:           "/callback",
+	Logout:             "/logout",
+	Userinfo:           "/userinfo",
+	BackchannelLogout:  "/backchannel-logout",
+	FrontchannelLogout: "/frontchannel-logout",
+}
+
+func (p Paths) withDefaults() Paths {
+	for _, f := range []struct {
+		v   *string
+		def string
+	}{
+		{&p.Login, defaultPaths.Login},
+		{&p.Callback, defaultPaths.Callback},
+		{&p.Logout, defaultPaths.Logout},
+		{&p.Userinfo, defaultPaths.Userinfo},
+		{&p.BackchannelLogout, defaultPaths.BackchannelLogout},
+		{&p.FrontchannelLogout, defaultPaths.FrontchannelLogout},
+	} {
+		if *f.v == "" {
+			*f.v = f.def
+		}
+	}
+	return p
+}
+
+// redirectURI returns the callback URL to send to the provider. With trusted
+// hosts configured it is built from the request's host and the mounted
+// callback path; otherwise the authenticator's configured RedirectURL is used.
+func redirectURI(deps *deps, r *http.Request) (string, error) {
+	if len(deps.trustedHosts) == 0 {
+		return deps.authenticator(r.Context()).RedirectURL, nil
+	}
+
+	origin, err := requestOrigin(deps, r)
+	if err != nil {
+		return "", err
+	}
+	return origin + deps.pathPrefix + deps.paths.Callback, nil
+}
+
+// logoutReturnTo returns the URL the provider sends users to after logout. A
+// path is resolved like redirectURI: against the request's host when trusted
+// hosts are configured, otherwise against the authenticator's RedirectURL.
+func logoutReturnTo(deps *deps, r *http.Request) (string, error) {
+	target := deps.postLogoutRedirect
+	if !strings.HasPrefix(target, "/") {
+		return target, nil
+	}
+
+	if len(deps.trustedHosts) == 0 {
+		u, err := url.Parse(deps.authenticator(r.Context()).RedirectURL)
+		if err != nil || u.Host == "" {
+			return target, nil
+		}
+		return u.Scheme + "://" + u.Host + target, nil
+	}
+
+	origin, err := requestOrigin(deps, r)
+	if err != nil {
+		return "", err
+	}
+	return origin + target, nil
+}
+
+// requestOrigin returns the scheme and host of r, which must be one of the
+// trusted hosts.
+func requestOrigin(deps *deps, r *http.Request) (string, error) {
+	if !slices.Contains(deps.trustedHosts, r.Host) {
+		return "", ErrUntrustedHost
+	}
+
+	scheme := "http"
+	if r.TLS != nil || (fromTrustedProxy(deps, r) && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host, nil
+}
+
+// fromTrustedProxy reports whether r's peer is one of the trusted proxies,
+// whose X-Forwarded-Proto header can be believed.
+func fromTrustedProxy(deps *deps, r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	return slices.ContainsFunc(deps.trustedProxies, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	})
+}
+
+// HandleUserinfo returns the current user as JSON: the standard fields
+// merged with any custom claims. Anonymous requests get 401.
+func HandleUserinfo(deps *deps) http.Handler {
+	return Optional(authenticatedMiddleware(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, "authentication required"))
+			return
+		}
+
+		claims, err := user.CustomClaims()
+		if err != nil || claims == nil {
+			claims = make(map[string]any)
+		}
+		claims["sub"] = user.Sub
+		claims["name"] = user.Name
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+		claims["picture"] = user.Picture
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(claims)
+	})))
+}
+
+// cleanPrefix normalises a mount prefix to "" or "/segment" without a
+// trailing slash.
+func cleanPrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
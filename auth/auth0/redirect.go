@@ -0,0 +1,27 @@
+package auth0
+
+import (
+	"net/url"
+	"strings"
+)
+
+const ReturnToParam = "return_to"
+
+// safeRedirectPath reports whether target is a same-origin path that is safe
+// to redirect to after login, rejecting absolute and protocol-relative URLs
+// and backslash tricks that browsers normalise into them.
+func safeRedirectPath(target string) bool {
+	if target == "" || target[0] != '/' {
+		return false
+	}
+	if strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "" && u.Host == "" && u.User == nil
+}
@@ -4,8 +4,16 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
+// Register adds the auth routes to r. When WithPathPrefix is set, r must be
+// the router mounted at that prefix, e.g. r.Route("/auth", registerRoutes).
 func Register(r chi.Router, deps *deps) {
-	r.Get("/login", HandleLogin(deps))
-	r.Get("/callback", HandleCallback(deps))
-	r.Get("/logout", HandleLogout(deps))
+	r.Method("GET", deps.paths.Login, tenantMiddleware(deps, HandleLogin(deps)))
+	r.Get(deps.paths.Callback, HandleCallback(deps))
+	r.Method("GET", deps.paths.Logout, tenantMiddleware(deps, HandleLogout(deps)))
+	r.Method("GET", deps.paths.Userinfo, HandleUserinfo(deps))
+
+	if deps.sessionIndex != nil {
+		r.Method("POST", deps.paths.BackchannelLogout, tenantMiddleware(deps, HandleBackchannelLogout(deps)))
+		r.Method("GET", deps.paths.FrontchannelLogout, tenantMiddleware(deps, HandleFrontchannelLogout(deps)))
+	}
 }
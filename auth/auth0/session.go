@@ -1,6 +1,9 @@
 package auth0
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type SessionUser struct {
 	Sub           string `json:"sub"`
@@ -12,6 +15,9 @@ type SessionUser struct {
 	Custom json.RawMessage `json:"-"`
 }
 
+// standardClaims are decoded into SessionUser fields and kept out of Custom.
+var standardClaims = []string{"sub", "name", "email", "email_verified", "picture"}
+
 func (u *SessionUser) CustomClaims() (map[string]any, error) {
 	if len(u.Custom) == 0 {
 		return nil, nil
@@ -24,3 +30,28 @@ func (u *SessionUser) CustomClaims() (map[string]any, error) {
 
 	return claims, nil
 }
+
+func userFromClaims(raw []byte) (SessionUser, error) {
+	var user SessionUser
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return SessionUser{}, fmt.Errorf("%w: %w", ErrInvalidClaims, err)
+	}
+
+	var customMap map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &customMap); err != nil {
+		return SessionUser{}, fmt.Errorf("%w: %w", ErrInvalidClaims, err)
+	}
+	for _, k := range standardClaims {
+		delete(customMap, k)
+	}
+
+	if len(customMap) > 0 {
+		custom, err := json.Marshal(customMap)
+		if err != nil {
+			return SessionUser{}, err
+		}
+		user.Custom = custom
+	}
+
+	return user, nil
+}
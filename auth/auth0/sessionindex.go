@@ -0,0 +1,225 @@
+package auth0
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Revocation invalidates the single session SID, or every session of Sub when
+// SID is empty, that was authenticated before At. Sub is kept alongside a SID
+// for logging only; it does not widen the revocation.
+type Revocation struct {
+	Sub string    `json:"sub,omitempty"`
+	SID string    `json:"sid,omitempty"`
+	At  time.Time `json:"at"`
+}
+
+// SessionIndex records revocations and is consulted by the middleware on every
+// request. Because sessions are checked against the index rather than
+// deleted from the store, it works with any SessionManager, including scs.
+type SessionIndex interface {
+	Revoke(ctx context.Context, rev Revocation) error
+	IsRevoked(ctx context.Context, sub, sid string, authTime time.Time) bool
+}
+
+// MemorySessionIndex keeps revocations in process. Entries are dropped after
+// ttl, which should be at least the maximum session age.
+type MemorySessionIndex struct {
+	ttl time.Duration
+
+	mu   sync.RWMutex
+	subs map[string]time.Time
+	sids map[string]time.Time
+}
+
+func NewMemorySessionIndex(ttl time.Duration) *MemorySessionIndex {
+	return &MemorySessionIndex{
+		ttl:  ttl,
+		subs: make(map[string]time.Time),
+		sids: make(map[string]time.Time),
+	}
+}
+
+func (m *MemorySessionIndex) Revoke(_ context.Context, rev Revocation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rev.At.IsZero() {
+		rev.At = time.Now()
+	}
+	switch {
+	case rev.SID != "":
+		if rev.At.After(m.sids[rev.SID]) {
+			m.sids[rev.SID] = rev.At
+		}
+	case rev.Sub != "":
+		if rev.At.After(m.subs[rev.Sub]) {
+			m.subs[rev.Sub] = rev.At
+		}
+	}
+
+	m.prune()
+	return nil
+}
+
+func (m *MemorySessionIndex) IsRevoked(_ context.Context, sub, sid string, authTime time.Time) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if at, ok := m.subs[sub]; ok && sub != "" && !authTime.After(at) {
+		return true
+	}
+	if at, ok := m.sids[sid]; ok && sid != "" && !authTime.After(at) {
+		return true
+	}
+	return false
+}
+
+// prune removes expired entries. Must be called with m.mu held.
+func (m *MemorySessionIndex) prune() {
+	if m.ttl <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-m.ttl)
+	for k, at := range m.subs {
+		if at.Before(cutoff) {
+			delete(m.subs, k)
+		}
+	}
+	for k, at := range m.sids {
+		if at.Before(cutoff) {
+			delete(m.sids, k)
+		}
+	}
+}
+
+// NATSSessionIndex stores revocations in a JetStream key/value bucket, so
+// they reach every replica and survive restarts. Existing revocations are
+// loaded before NewNATSSessionIndex returns; later ones are watched and
+// applied to a local MemorySessionIndex, which answers IsRevoked.
+type NATSSessionIndex struct {
+	*MemorySessionIndex
+
+	kv nats.KeyValue
+	w  nats.KeyWatcher
+}
+
+// NewNATSSessionIndex uses bucket, creating it with ttl as the max age of
+// its entries if it does not exist.
+func NewNATSSessionIndex(js nats.JetStreamContext, bucket string, ttl time.Duration) (*NATSSessionIndex, error) {
+	if bucket == "" {
+		return nil, ErrBucketRequired
+	}
+
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, TTL: ttl})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := kv.WatchAll(nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &NATSSessionIndex{
+		MemorySessionIndex: NewMemorySessionIndex(ttl),
+		kv:                 kv,
+		w:                  w,
+	}
+
+	// The watcher replays the current values, then sends nil.
+	for entry := range w.Updates() {
+		if entry == nil {
+			break
+		}
+		idx.apply(entry)
+	}
+
+	go func() {
+		for entry := range w.Updates() {
+			if entry != nil {
+				idx.apply(entry)
+			}
+		}
+	}()
+
+	return idx, nil
+}
+
+func (n *NATSSessionIndex) apply(entry nats.KeyValueEntry) {
+	var rev Revocation
+	if err := json.Unmarshal(entry.Value(), &rev); err != nil {
+		return
+	}
+	n.MemorySessionIndex.Revoke(context.Background(), rev)
+}
+
+func (n *NATSSessionIndex) Revoke(ctx context.Context, rev Revocation) error {
+	if rev.At.IsZero() {
+		rev.At = time.Now()
+	}
+
+	// Apply locally right away so this replica does not depend on the round trip.
+	n.MemorySessionIndex.Revoke(ctx, rev)
+
+	switch {
+	case rev.SID != "":
+		return n.put(ctx, "sid."+revocationKey(rev.SID), Revocation{SID: rev.SID, At: rev.At})
+	case rev.Sub != "":
+		return n.put(ctx, "sub."+revocationKey(rev.Sub), Revocation{Sub: rev.Sub, At: rev.At})
+	}
+	return nil
+}
+
+// put stores rev under key unless a later revocation is already stored there.
+func (n *NATSSessionIndex) put(ctx context.Context, key string, rev Revocation) error {
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		entry, err := n.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			if _, err = n.kv.Create(key, data); errors.Is(err, nats.ErrKeyExists) {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored Revocation
+		if json.Unmarshal(entry.Value(), &stored) == nil && !stored.At.Before(rev.At) {
+			return nil
+		}
+		if _, err = n.kv.Update(key, data, entry.Revision()); !errors.Is(err, nats.ErrKeyExists) {
+			return err
+		}
+	}
+}
+
+func (n *NATSSessionIndex) Close() error {
+	return n.w.Stop()
+}
+
+// revocationKey encodes a sub or sid, which may contain characters such as
+// "|" that are not valid in key names.
+func revocationKey(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
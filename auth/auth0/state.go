@@ -0,0 +1,153 @@
+package auth0
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"maps"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// loginState is the single-use login transaction kept in the session between
+// the redirect to the provider and the callback.
+type loginState struct {
+	State       string
+	Nonce       string
+	Verifier    string
+	ReturnTo    string
+	RedirectURI string
+	Tenant      string
+	MaxAge      time.Duration
+	CreatedAt   time.Time
+}
+
+func newLoginState() (*loginState, error) {
+	state, err := generateRandomState()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateRandomState()
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginState{
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  oauth2.GenerateVerifier(),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// beginLogin stores a new login transaction in the session and returns the
+// provider's authorization URL for it. returnTo is kept only if it is a safe
+// same-origin path. A positive maxAge forces re-authentication at the provider.
+func beginLogin(deps *deps, r *http.Request, returnTo string, maxAge time.Duration) (*loginState, string, error) {
+	ls, err := newLoginState()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if safeRedirectPath(returnTo) {
+		ls.ReturnTo = returnTo
+	}
+
+	if ls.RedirectURI, err = redirectURI(deps, r); err != nil {
+		return nil, "", err
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oidc.Nonce(ls.Nonce),
+		oauth2.S256ChallengeOption(ls.Verifier),
+	}
+	if ls.RedirectURI != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", ls.RedirectURI))
+	}
+
+	params := url.Values{}
+	for _, h := range deps.preLoginHooks {
+		if err = runHook(r.Context(), deps, "pre-login", h.hookConfig, func(ctx context.Context) error {
+			// As with post-login hooks, a failed attempt leaves params untouched.
+			attempt := maps.Clone(params)
+			if err := h.fn(ctx, r, attempt); err != nil {
+				return err
+			}
+			params = attempt
+			return nil
+		}); err != nil {
+			return nil, "", err
+		}
+	}
+	for k := range params {
+		opts = append(opts, oauth2.SetAuthURLParam(k, params.Get(k)))
+	}
+
+	if t, ok := TenantFromContext(r.Context()); ok {
+		ls.Tenant = t.ID
+		if t.Organization != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("organization", t.Organization))
+		}
+		if t.Connection != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("connection", t.Connection))
+		}
+	}
+
+	if maxAge > 0 {
+		ls.MaxAge = maxAge
+		opts = append(opts,
+			oauth2.SetAuthURLParam("prompt", "login"),
+			oauth2.SetAuthURLParam("max_age", maxAgeParam(maxAge)),
+		)
+	}
+
+	deps.sessions.Put(r.Context(), StateKey, *ls)
+
+	return ls, deps.authenticator(r.Context()).AuthCodeURL(ls.State, opts...), nil
+}
+
+// consumeLoginState validates the callback's state parameter against the
+// session and removes the transaction so it cannot be replayed.
+func consumeLoginState(deps *deps, r *http.Request) (*loginState, error) {
+	ls, ok := deps.sessions.Get(r.Context(), StateKey).(loginState)
+	if !ok {
+		return nil, ErrStateMissing
+	}
+
+	deps.sessions.Put(r.Context(), StateKey, nil)
+
+	got := r.URL.Query().Get("state")
+	if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(ls.State)) != 1 {
+		return nil, ErrStateMismatch
+	}
+
+	if time.Since(ls.CreatedAt) > deps.stateTTL {
+		return nil, ErrStateExpired
+	}
+
+	return &ls, nil
+}
+
+func loginError(deps *deps, w http.ResponseWriter, r *http.Request, err error) {
+	deps.loginFailed(r, "", err.Error())
+
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		deps.errorHandler(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if errors.Is(err, ErrUntrustedHost) {
+		deps.log.Warn("login from untrusted host", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deps.log.Error("unable to begin login", "error", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,152 @@
+package auth0
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/derekmwright/web/auth/auth0/authenticator"
+)
+
+// Tenant is a customer organisation with its own login configuration.
+type Tenant struct {
+	ID string
+	// Organization is sent as the Auth0 organization parameter and checked
+	// against the ID token's org_id claim.
+	Organization string
+	// Connection preselects an identity provider, e.g. a customer's SAML or
+	// enterprise connection.
+	Connection string
+	// Authenticator overrides the default client for this tenant, e.g. a
+	// separate Auth0 application or OIDC issuer. Nil uses the default.
+	Authenticator *authenticator.Authenticator
+}
+
+// TenantKey extracts the tenant identifier from a request, or "" if there is
+// none.
+type TenantKey func(r *http.Request) string
+
+// TenantSource looks up a tenant by identifier. It returns ErrUnknownTenant
+// for identifiers it does not know.
+type TenantSource func(ctx context.Context, id string) (*Tenant, error)
+
+type tenantContextKey struct{}
+
+// HostTenant uses the full request host, without port, as the tenant ID.
+func HostTenant() TenantKey {
+	return func(r *http.Request) string {
+		return hostname(r.Host)
+	}
+}
+
+// SubdomainTenant uses the label in front of base, so acme.app.example.com
+// with base "app.example.com" resolves to "acme".
+func SubdomainTenant(base string) TenantKey {
+	suffix := "." + strings.TrimPrefix(base, ".")
+	return func(r *http.Request) string {
+		sub, ok := strings.CutSuffix(hostname(r.Host), suffix)
+		if !ok || strings.Contains(sub, ".") {
+			return ""
+		}
+		return sub
+	}
+}
+
+// PathTenant uses the first path segment, so /acme/orders resolves to "acme".
+// Register the auth routes at the root as well as any tenant-scoped routes;
+// the callback recovers the tenant from the login state.
+func PathTenant() TenantKey {
+	return func(r *http.Request) string {
+		seg, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		return seg
+	}
+}
+
+// StaticTenants serves a fixed set of tenants.
+func StaticTenants(tenants ...Tenant) TenantSource {
+	byID := make(map[string]*Tenant, len(tenants))
+	for i := range tenants {
+		byID[tenants[i].ID] = &tenants[i]
+	}
+
+	return func(ctx context.Context, id string) (*Tenant, error) {
+		t, ok := byID[id]
+		if !ok {
+			return nil, ErrUnknownTenant
+		}
+		return t, nil
+	}
+}
+
+// TenantFromContext returns the tenant resolved for the request, e.g. to
+// scope database queries.
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantContextKey{}).(*Tenant)
+	return t, ok
+}
+
+func ContextWithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// resolveTenant places the request's tenant in its context. When tenancy is
+// enabled and no tenant matches, it responds with 404 and returns false.
+func resolveTenant(deps *deps, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if deps.tenantKey == nil {
+		return r, true
+	}
+	if _, ok := TenantFromContext(r.Context()); ok {
+		return r, true
+	}
+
+	tenant, err := lookupTenant(r.Context(), deps, deps.tenantKey(r))
+	if err != nil {
+		deps.log.Warn("unable to resolve tenant", "host", r.Host, "path", r.URL.Path, "error", err)
+		http.NotFound(w, r)
+		return r, false
+	}
+
+	return r.WithContext(ContextWithTenant(r.Context(), tenant)), true
+}
+
+func lookupTenant(ctx context.Context, deps *deps, id string) (*Tenant, error) {
+	if id == "" {
+		return nil, ErrUnknownTenant
+	}
+	return deps.tenantSource(ctx, id)
+}
+
+// tenantMiddleware resolves the tenant before running next.
+func tenantMiddleware(deps *deps, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ok := resolveTenant(deps, w, r)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticator returns the tenant's client if it has one, or the default.
+func (d *deps) authenticator(ctx context.Context) *authenticator.Authenticator {
+	if t, ok := TenantFromContext(ctx); ok && t.Authenticator != nil {
+		return t.Authenticator
+	}
+	return d.auth
+}
+
+// tenantID returns the ID of the tenant in ctx, or "".
+func tenantID(ctx context.Context) string {
+	if t, ok := TenantFromContext(ctx); ok {
+		return t.ID
+	}
+	return ""
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
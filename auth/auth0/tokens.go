@@ -0,0 +1,136 @@
+package auth0
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+)
+
+const TokenKey = "token"
+
+// sessionToken is the gob-friendly form of oauth2.Token kept in the session.
+type sessionToken struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+func (t sessionToken) oauth2() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
+	}
+}
+
+func storeToken(ctx context.Context, deps *deps, token *oauth2.Token) {
+	deps.sessions.Put(ctx, TokenKey, sessionToken{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	})
+	deps.sessions.Put(ctx, "access_token", token.AccessToken)
+}
+
+// refreshGroup collapses concurrent refreshes of the same refresh token, which
+// providers with refresh token rotation would otherwise reject.
+var refreshGroup singleflight.Group
+
+// TokenSource returns an oauth2.TokenSource for the session's tokens. It
+// refreshes the access token when it is about to expire and stores the result
+// back in the session. It is only available on routes behind the middleware
+// returned by New. While impersonating it returns ErrImpersonating, since the
+// tokens belong to the actor rather than the impersonated user.
+func TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	deps, ok := ctx.Value(depsContextKey{}).(*deps)
+	if !ok {
+		return nil, ErrNoToken
+	}
+	if IsImpersonating(ctx) {
+		return nil, ErrImpersonating
+	}
+	return &sessionTokenSource{ctx: ctx, deps: deps}, nil
+}
+
+// AccessToken returns a valid access token for calling downstream APIs. When
+// the token cannot be refreshed the session is invalidated and
+// ErrReauthRequired is returned; send the user through login again.
+func AccessToken(ctx context.Context) (string, error) {
+	ts, err := TokenSource(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
+
+// Client returns an HTTP client that authenticates requests with the
+// session's access token.
+func Client(ctx context.Context) (*http.Client, error) {
+	ts, err := TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+type sessionTokenSource struct {
+	ctx  context.Context
+	deps *deps
+}
+
+func (s *sessionTokenSource) Token() (*oauth2.Token, error) {
+	stored, ok := s.deps.sessions.Get(s.ctx, TokenKey).(sessionToken)
+	if !ok {
+		return nil, ErrNoToken
+	}
+
+	token := stored.oauth2()
+	if token.Expiry.IsZero() || time.Until(token.Expiry) > s.deps.refreshLeeway {
+		return token, nil
+	}
+
+	if token.RefreshToken == "" {
+		s.invalidate("access token expired and no refresh token is available")
+		return nil, ErrReauthRequired
+	}
+
+	v, err, _ := refreshGroup.Do(token.RefreshToken, func() (any, error) {
+		// Force a refresh; the token source would otherwise reuse a token
+		// inside our leeway window.
+		expired := *token
+		expired.Expiry = time.Now().Add(-time.Second)
+		return s.deps.authenticator(s.ctx).TokenSource(context.WithoutCancel(s.ctx), &expired).Token()
+	})
+	if err != nil {
+		s.deps.log.Warn("unable to refresh access token", "error", err)
+		s.invalidate("token refresh failed")
+		return nil, ErrReauthRequired
+	}
+
+	// The token is shared with every caller of the same refresh; copy it
+	// before filling in the refresh token.
+	refreshed := *v.(*oauth2.Token)
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	storeToken(s.ctx, s.deps, &refreshed)
+
+	return &refreshed, nil
+}
+
+func (s *sessionTokenSource) invalidate(reason string) {
+	s.deps.log.Info("invalidating session", "reason", reason)
+	endSession(s.ctx, s.deps)
+}
@@ -0,0 +1,12 @@
+package oidc
+
+import "errors"
+
+var (
+	ErrEmptyIssuer       = errors.New("issuer cannot be empty")
+	ErrEmptyClientID     = errors.New("client id cannot be empty")
+	ErrEmptyClientSecret = errors.New("client secret cannot be empty")
+	ErrEmptyRedirectURI  = errors.New("redirect uri cannot be empty")
+	ErrNoIDToken         = errors.New("no id_token field in oauth2 token")
+	ErrNoLogoutEndpoint  = errors.New("provider does not advertise an end_session_endpoint")
+)
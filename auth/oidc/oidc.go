@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Config describes a relying party registration with any OpenID Connect
+// provider (Auth0, Keycloak, Dex, Okta, Entra ID, ...).
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+	Quirks       Quirks
+}
+
+// Quirks covers provider behaviour that deviates from plain OIDC discovery.
+type Quirks struct {
+	// LogoutURL replaces the discovered end_session_endpoint, e.g. Auth0's
+	// legacy /v2/logout endpoint.
+	LogoutURL string
+	// LogoutRedirectParam names the post-logout redirect query parameter.
+	// Defaults to post_logout_redirect_uri; Auth0's /v2/logout uses returnTo.
+	LogoutRedirectParam string
+	// OmitIDTokenHint skips sending id_token_hint on logout.
+	OmitIDTokenHint bool
+	// DiscoveryIssuer is the issuer reported by the discovery document when it
+	// differs from Issuer, as with Entra ID's multi-tenant endpoints.
+	DiscoveryIssuer string
+	// SkipIssuerCheck disables ID token issuer validation. Only use it when the
+	// issuer varies per tenant and is validated elsewhere.
+	SkipIssuerCheck bool
+	// AuthParams are added to every authorization request, e.g. audience.
+	AuthParams map[string]string
+}
+
+type Authenticator struct {
+	*gooidc.Provider
+	oauth2.Config
+
+	Issuer             string
+	EndSessionEndpoint string
+	Quirks             Quirks
+}
+
+func New(ctx context.Context, cfg Config) (*Authenticator, error) {
+	if cfg.Issuer == "" {
+		return nil, ErrEmptyIssuer
+	}
+	if cfg.ClientID == "" {
+		return nil, ErrEmptyClientID
+	}
+	if cfg.ClientSecret == "" {
+		return nil, ErrEmptyClientSecret
+	}
+	if cfg.RedirectURI == "" {
+		return nil, ErrEmptyRedirectURI
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{gooidc.ScopeOpenID, "profile", "email"}
+	}
+
+	if cfg.Quirks.DiscoveryIssuer != "" {
+		ctx = gooidc.InsecureIssuerURLContext(ctx, cfg.Quirks.DiscoveryIssuer)
+	}
+
+	provider, err := gooidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err = provider.Claims(&meta); err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		Provider: provider,
+		Config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURI,
+			Scopes:       cfg.Scopes,
+			Endpoint:     provider.Endpoint(),
+		},
+		Issuer:             cfg.Issuer,
+		EndSessionEndpoint: meta.EndSessionEndpoint,
+		Quirks:             cfg.Quirks,
+	}, nil
+}
+
+// ConfigFromEnv reads a Config from <prefix>_ISSUER, <prefix>_CLIENT_ID,
+// <prefix>_CLIENT_SECRET, <prefix>_REDIRECT_URI and the optional
+// comma-separated <prefix>_SCOPES.
+func ConfigFromEnv(prefix string) Config {
+	cfg := Config{
+		Issuer:       os.Getenv(prefix + "_ISSUER"),
+		ClientID:     os.Getenv(prefix + "_CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "_CLIENT_SECRET"),
+		RedirectURI:  os.Getenv(prefix + "_REDIRECT_URI"),
+	}
+
+	if scopes := os.Getenv(prefix + "_SCOPES"); scopes != "" {
+		for _, s := range strings.Split(scopes, ",") {
+			cfg.Scopes = append(cfg.Scopes, strings.TrimSpace(s))
+		}
+	}
+
+	return cfg
+}
+
+func (a *Authenticator) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	for k, v := range a.Quirks.AuthParams {
+		opts = append(opts, oauth2.SetAuthURLParam(k, v))
+	}
+	return a.Config.AuthCodeURL(state, opts...)
+}
+
+func (a *Authenticator) VerifyIDToken(ctx context.Context, token *oauth2.Token) (*gooidc.IDToken, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrNoIDToken
+	}
+
+	return a.Verifier(&gooidc.Config{
+		ClientID:        a.ClientID,
+		SkipIssuerCheck: a.Quirks.SkipIssuerCheck,
+	}).Verify(ctx, rawIDToken)
+}
+
+// LogoutURL builds an RP-initiated logout URL that returns the browser to
+// returnTo. idTokenHint may be empty.
+func (a *Authenticator) LogoutURL(returnTo, idTokenHint string) (string, error) {
+	endpoint := a.Quirks.LogoutURL
+	if endpoint == "" {
+		endpoint = a.EndSessionEndpoint
+	}
+	if endpoint == "" {
+		return "", ErrNoLogoutEndpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	param := a.Quirks.LogoutRedirectParam
+	if param == "" {
+		param = "post_logout_redirect_uri"
+	}
+
+	q := u.Query()
+	q.Set(param, returnTo)
+	q.Set("client_id", a.ClientID)
+	if idTokenHint != "" && !a.Quirks.OmitIDTokenHint {
+		q.Set("id_token_hint", idTokenHint)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,68 @@
+package oidc
+
+import (
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestLogoutURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      Authenticator
+		wantBase  string
+		wantQuery url.Values
+		wantErr   error
+	}{
+		{
+			name:     "discovered end session endpoint",
+			auth:     Authenticator{EndSessionEndpoint: "https://idp.example.com/logout"},
+			wantBase: "https://idp.example.com/logout",
+			wantQuery: url.Values{
+				"post_logout_redirect_uri": {"https://app.example.com"},
+				"client_id":                {"client"},
+				"id_token_hint":            {"raw-id-token"},
+			},
+		},
+		{
+			name: "auth0 legacy logout",
+			auth: Authenticator{Quirks: Quirks{
+				LogoutURL:           "https://tenant.auth0.com/v2/logout",
+				LogoutRedirectParam: "returnTo",
+				OmitIDTokenHint:     true,
+			}},
+			wantBase: "https://tenant.auth0.com/v2/logout",
+			wantQuery: url.Values{
+				"returnTo":  {"https://app.example.com"},
+				"client_id": {"client"},
+			},
+		},
+		{
+			name:    "no logout endpoint",
+			wantErr: ErrNoLogoutEndpoint,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.auth.Config = oauth2.Config{ClientID: "client"}
+
+			got, err := tt.auth.LogoutURL("https://app.example.com", "raw-id-token")
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			u, _ := url.Parse(got)
+			if base := u.Scheme + "://" + u.Host + u.Path; base != tt.wantBase {
+				t.Errorf("base = %q, want %q", base, tt.wantBase)
+			}
+			if u.Query().Encode() != tt.wantQuery.Encode() {
+				t.Errorf("query = %q, want %q", u.Query().Encode(), tt.wantQuery.Encode())
+			}
+		})
+	}
+}
@@ -0,0 +1,509 @@
+// Package oidctest runs an in-process OpenID Connect provider for tests and
+// offline development. It serves discovery, JWKS, authorize, token, userinfo
+// and logout endpoints and issues RS256 signed tokens for configured users.
+// Logins are approved without any UI.
+package oidctest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekmwright/web/auth/oidc"
+)
+
+// User is an identity the provider can log in. Claims are merged into the
+// ID token, access token and userinfo response, e.g. roles or permissions.
+type User struct {
+	Sub           string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Claims        map[string]any
+}
+
+// Provider is a running fake issuer. Its URL is the issuer identifier.
+type Provider struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	audience   string
+	tokenTTL   time.Duration
+	noAuthTime bool
+	key        *rsa.PrivateKey
+	ts         *httptest.Server
+
+	mu       sync.Mutex
+	users    []User
+	codes    map[string]authRequest
+	refresh  map[string]authRequest
+	loginErr string
+	logouts  int
+}
+
+type authRequest struct {
+	user        User
+	nonce       string
+	sid         string
+	redirectURI string
+	challenge   string
+	scopes      []string
+	authTime    time.Time
+}
+
+func New(opts ...Option) (*Provider, error) {
+	cfg := config{
+		clientID:     "oidctest-client",
+		clientSecret: "oidctest-secret",
+		tokenTTL:     time.Hour,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if len(cfg.users) == 0 {
+		cfg.users = []User{{Sub: "oidctest|1", Name: "Test User", Email: "test@example.com", EmailVerified: true}}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		ClientID:     cfg.clientID,
+		ClientSecret: cfg.clientSecret,
+		audience:     cfg.audience,
+		tokenTTL:     cfg.tokenTTL,
+		noAuthTime:   cfg.noAuthTime,
+		key:          key,
+		users:        cfg.users,
+		codes:        make(map[string]authRequest),
+		refresh:      make(map[string]authRequest),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("GET /.well-known/jwks.json", p.handleJWKS)
+	mux.HandleFunc("GET /authorize", p.handleAuthorize)
+	mux.HandleFunc("POST /oauth/token", p.handleToken)
+	mux.HandleFunc("GET /userinfo", p.handleUserinfo)
+	mux.HandleFunc("GET /logout", p.handleLogout)
+
+	p.ts = httptest.NewUnstartedServer(mux)
+	if cfg.addr != "" {
+		l, err := net.Listen("tcp", cfg.addr)
+		if err != nil {
+			return nil, err
+		}
+		p.ts.Listener.Close()
+		p.ts.Listener = l
+	}
+	p.ts.Start()
+	p.URL = p.ts.URL
+
+	return p, nil
+}
+
+func (p *Provider) Close() {
+	p.ts.Close()
+}
+
+// Config returns an oidc.Config for this provider's client.
+func (p *Provider) Config(redirectURI string) oidc.Config {
+	return oidc.Config{
+		Issuer:       p.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURI:  redirectURI,
+	}
+}
+
+// Authenticator discovers this provider, ready for auth0.WithAuthenticator.
+func (p *Provider) Authenticator(ctx context.Context, redirectURI string) (*oidc.Authenticator, error) {
+	return oidc.New(ctx, p.Config(redirectURI))
+}
+
+// SetUser makes u the user logged in by the next authorization requests that
+// carry no login_hint.
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.users = append([]User{u}, slices.DeleteFunc(p.users, func(existing User) bool {
+		return existing.Sub == u.Sub
+	})...)
+}
+
+// FailNextLogin makes the next authorization request redirect back with the
+// given OAuth error code, e.g. "access_denied".
+func (p *Provider) FailNextLogin(code string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.loginErr = code
+}
+
+// Logouts reports how many times the logout endpoint was hit.
+func (p *Provider) Logouts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.logouts
+}
+
+// AccessToken issues a signed access token for u, as if obtained through a
+// login, for testing bearer authentication.
+func (p *Provider) AccessToken(u User, scopes ...string) (string, error) {
+	return p.sign(p.accessClaims(u, scopes))
+}
+
+// Sign signs arbitrary claims with the provider's key, for tokens a real
+// provider would not issue, e.g. with the wrong audience or already expired.
+func (p *Provider) Sign(claims map[string]any) (string, error) {
+	return p.sign(claims)
+}
+
+// LogoutToken issues a back-channel logout token for sub and/or sid.
+func (p *Provider) LogoutToken(sub, sid string) (string, error) {
+	now := time.Now()
+	claims := map[string]any{
+		"iss":    p.issuer(),
+		"aud":    p.ClientID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(2 * time.Minute).Unix(),
+		"jti":    randomString(),
+		"events": map[string]any{"http://schemas.openid.net/event/backchannel-logout": map[string]any{}},
+	}
+	if sub != "" {
+		claims["sub"] = sub
+	}
+	if sid != "" {
+		claims["sid"] = sid
+	}
+	return p.sign(claims)
+}
+
+func (p *Provider) issuer() string {
+	return p.URL
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer(),
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/oauth/token",
+		"userinfo_endpoint":                     p.URL + "/userinfo",
+		"jwks_uri":                              p.URL + "/.well-known/jwks.json",
+		"end_session_endpoint":                  p.URL + "/logout",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"backchannel_logout_supported":          true,
+		"backchannel_logout_session_supported":  true,
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.jwks())
+}
+
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != p.ClientID || redirectURI.Scheme == "" {
+		http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	rq := redirectURI.Query()
+	rq.Set("state", q.Get("state"))
+
+	p.mu.Lock()
+	loginErr := p.loginErr
+	p.loginErr = ""
+	user, ok := p.user(q.Get("login_hint"))
+	p.mu.Unlock()
+
+	switch {
+	case loginErr != "":
+		rq.Set("error", loginErr)
+	case !ok:
+		rq.Set("error", "login_required")
+	case q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256":
+		rq.Set("error", "invalid_request")
+	default:
+		code := randomString()
+
+		p.mu.Lock()
+		p.codes[code] = authRequest{
+			user:        user,
+			nonce:       q.Get("nonce"),
+			sid:         randomString(),
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+			scopes:      strings.Fields(q.Get("scope")),
+			authTime:    time.Now(),
+		}
+		p.mu.Unlock()
+
+		rq.Set("code", code)
+	}
+
+	redirectURI.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		p.mu.Lock()
+		req, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || req.redirectURI != r.PostFormValue("redirect_uri") || b64(sum[:]) != req.challenge {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		p.issueTokens(w, req)
+
+	case "refresh_token":
+		p.mu.Lock()
+		req, ok := p.refresh[r.PostFormValue("refresh_token")]
+		delete(p.refresh, r.PostFormValue("refresh_token"))
+		p.mu.Unlock()
+
+		if !ok {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		req.nonce = ""
+		p.issueTokens(w, req)
+
+	case "client_credentials":
+		claims := p.accessClaims(User{Sub: clientID + "@clients"}, strings.Fields(r.PostFormValue("scope")))
+		if aud := r.PostFormValue("audience"); aud != "" {
+			claims["aud"] = aud
+		}
+		claims["gty"] = "client-credentials"
+
+		access, err := p.sign(claims)
+		if err != nil {
+			tokenError(w, http.StatusInternalServerError, "server_error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": access,
+			"token_type":   "Bearer",
+			"expires_in":   int(p.tokenTTL.Seconds()),
+		})
+
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+func (p *Provider) issueTokens(w http.ResponseWriter, req authRequest) {
+	now := time.Now()
+
+	idClaims := userClaims(req.user)
+	idClaims["iss"] = p.issuer()
+	idClaims["aud"] = p.ClientID
+	idClaims["iat"] = now.Unix()
+	idClaims["exp"] = now.Add(p.tokenTTL).Unix()
+	if !p.noAuthTime {
+		idClaims["auth_time"] = req.authTime.Unix()
+	}
+	idClaims["sid"] = req.sid
+	if req.nonce != "" {
+		idClaims["nonce"] = req.nonce
+	}
+
+	idToken, err := p.sign(idClaims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	access, err := p.sign(p.accessClaims(req.user, req.scopes))
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	resp := map[string]any{
+		"access_token": access,
+		"id_token":     idToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(p.tokenTTL.Seconds()),
+	}
+
+	if slices.Contains(req.scopes, "offline_access") {
+		refresh := randomString()
+		p.mu.Lock()
+		p.refresh[refresh] = req
+		p.mu.Unlock()
+		resp["refresh_token"] = refresh
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (p *Provider) handleUserinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "bearer token required", http.StatusUnauthorized)
+		return
+	}
+
+	// Tokens are only ever issued by this provider; decoding the payload is
+	// enough to find the user.
+	parts := strings.Split(token, ".")
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if len(parts) != 3 || json.Unmarshal(decodeSegment(parts[1]), &claims) != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	p.mu.Lock()
+	user, ok := p.user(claims.Sub)
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown user", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userClaims(user))
+}
+
+func (p *Provider) handleLogout(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.logouts++
+	p.mu.Unlock()
+
+	q := r.URL.Query()
+	target := q.Get("post_logout_redirect_uri")
+	if target == "" {
+		target = q.Get("returnTo")
+	}
+	if target == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if state := q.Get("state"); state != "" {
+		u, err := url.Parse(target)
+		if err == nil {
+			uq := u.Query()
+			uq.Set("state", state)
+			u.RawQuery = uq.Encode()
+			target = u.String()
+		}
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// user returns the user with the given sub, or the default user when sub is
+// empty. Must be called with p.mu held.
+func (p *Provider) user(sub string) (User, bool) {
+	if sub == "" {
+		if len(p.users) == 0 {
+			return User{}, false
+		}
+		return p.users[0], true
+	}
+
+	i := slices.IndexFunc(p.users, func(u User) bool { return u.Sub == sub })
+	if i < 0 {
+		return User{}, false
+	}
+	return p.users[i], true
+}
+
+func (p *Provider) accessClaims(u User, scopes []string) map[string]any {
+	now := time.Now()
+
+	claims := map[string]any{}
+	for k, v := range u.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = p.issuer()
+	claims["sub"] = u.Sub
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(p.tokenTTL).Unix()
+	claims["scope"] = strings.Join(scopes, " ")
+	if p.audience != "" {
+		claims["aud"] = p.audience
+	} else {
+		claims["aud"] = p.ClientID
+	}
+
+	return claims
+}
+
+func userClaims(u User) map[string]any {
+	claims := map[string]any{}
+	for k, v := range u.Claims {
+		claims[k] = v
+	}
+	claims["sub"] = u.Sub
+	if u.Name != "" {
+		claims["name"] = u.Name
+	}
+	if u.Email != "" {
+		claims["email"] = u.Email
+		claims["email_verified"] = u.EmailVerified
+	}
+	return claims
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b64(b)
+}
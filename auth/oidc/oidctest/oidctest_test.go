@@ -0,0 +1,55 @@
+package oidctest
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p, err := New(WithUser(User{Sub: "user|1", Name: "Ada"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	auth, err := p.Authenticator(t.Context(), "http://app.test/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(auth.AuthCodeURL("st", oauth2.S256ChallengeOption(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Query().Get("state") != "st" {
+		t.Fatalf("unexpected redirect %q", resp.Header.Get("Location"))
+	}
+
+	token, err := auth.Exchange(t.Context(), loc.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idToken, err := auth.VerifyIDToken(t.Context(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Subject != "user|1" {
+		t.Errorf("sub = %q, want %q", idToken.Subject, "user|1")
+	}
+
+	if _, err = auth.Exchange(t.Context(), loc.Query().Get("code"), oauth2.VerifierOption(verifier)); err == nil {
+		t.Error("authorization code should be single-use")
+	}
+}
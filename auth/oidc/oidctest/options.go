@@ -0,0 +1,49 @@
+package oidctest
+
+import "time"
+
+type Option func(*config)
+
+type config struct {
+	clientID     string
+	clientSecret string
+	audience     string
+	addr         string
+	tokenTTL     time.Duration
+	noAuthTime   bool
+	users        []User
+}
+
+func WithClient(id, secret string) Option {
+	return func(c *config) {
+		c.clientID = id
+		c.clientSecret = secret
+	}
+}
+
+// WithAudience sets the aud claim of issued access tokens, so they can be
+// checked by auth0.NewBearer.
+func WithAudience(aud string) Option {
+	return func(c *config) { c.audience = aud }
+}
+
+// WithAddr listens on a fixed address instead of an ephemeral port, giving a
+// stable issuer URL for offline development.
+func WithAddr(addr string) Option {
+	return func(c *config) { c.addr = addr }
+}
+
+func WithTokenTTL(d time.Duration) Option {
+	return func(c *config) { c.tokenTTL = d }
+}
+
+// WithoutAuthTime leaves auth_time out of ID tokens, as some providers do.
+func WithoutAuthTime() Option {
+	return func(c *config) { c.noAuthTime = true }
+}
+
+// WithUser registers a user that can log in. The first registered user is
+// logged in unless the authorization request names another via login_hint.
+func WithUser(u User) Option {
+	return func(c *config) { c.users = append(c.users, u) }
+}
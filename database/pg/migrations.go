@@ -1,6 +1,7 @@
 package pg
 
 import (
+	"context"
 	"errors"
 	"io/fs"
 
@@ -8,28 +9,71 @@ import (
 	"github.com/pressly/goose/v3"
 )
 
-func Migrate(db *Database, sourceFS fs.FS, dir string) error {
+// DefaultMigrationsTable is the goose version table used when no table is
+// given to Migrate.
+const DefaultMigrationsTable = "goose_db_version"
+
+type MigrateOption func(*migrateConfig)
+
+type migrateConfig struct {
+	table      string
+	outOfOrder bool
+}
+
+// WithMigrationsTable records applied versions in table instead of
+// DefaultMigrationsTable. Each package in this module ships its own table
+// name as MigrationsTable, so packages and the application can be migrated in
+// any order.
+func WithMigrationsTable(table string) MigrateOption {
+	return func(c *migrateConfig) { c.table = table }
+}
+
+// WithOutOfOrder also applies migrations with a lower version than ones
+// already applied, e.g. one merged from an older branch. By default goose
+// rejects them.
+func WithOutOfOrder() MigrateOption {
+	return func(c *migrateConfig) { c.outOfOrder = true }
+}
+
+// Migrate applies the goose migrations in dir of sourceFS.
+func Migrate(db *Database, sourceFS fs.FS, dir string, opts ...MigrateOption) error {
 	if sourceFS == nil {
 		return errors.New("sourceFS required")
 	}
 
-	goose.SetDialect("postgres")
-	goose.SetBaseFS(sourceFS)
+	cfg := migrateConfig{table: DefaultMigrationsTable}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	fsys, err := fs.Sub(sourceFS, dir)
+	if err != nil {
+		return err
+	}
 
 	sqlDB := stdlib.OpenDBFromPool(db.Pool)
 	defer sqlDB.Close()
 
-	db.log.Info("running database migrations from", "dir", dir)
-	
-	err := goose.Up(sqlDB, dir)
+	provider, err := goose.NewProvider(goose.DialectPostgres, sqlDB, fsys,
+		goose.WithTableName(cfg.table),
+		goose.WithAllowOutofOrder(cfg.outOfOrder),
+	)
+	if err != nil {
+		return err
+	}
+
+	db.log.Info("running database migrations", "dir", dir, "table", cfg.table)
+
+	results, err := provider.Up(context.Background())
 	if err != nil {
-		if errors.Is(err, goose.ErrNoNextVersion) {
-			db.log.Info("no new migrations to apply")
-			return nil
-		}
 		return err
 	}
 
-	db.log.Info("migrations completed successfully")
+	if len(results) == 0 {
+		db.log.Info("no new migrations to apply")
+		return nil
+	}
+
+	db.log.Info("migrations completed successfully", "applied", len(results))
 	return nil
 }
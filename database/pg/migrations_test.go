@@ -0,0 +1,111 @@
+package pg_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/derekmwright/web/audit"
+	"github.com/derekmwright/web/auth/apikey"
+	"github.com/derekmwright/web/database/pg"
+	"github.com/derekmwright/web/flags"
+	"github.com/derekmwright/web/idempotency"
+	"github.com/derekmwright/web/session"
+	"github.com/derekmwright/web/users"
+)
+
+func testDB(t *testing.T) *pg.Database {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := pg.New(pg.WithDSN(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// TestMigrateOrder needs a disposable database in TEST_DATABASE_URL.
+func TestMigrateOrder(t *testing.T) {
+	db := testDB(t)
+
+	// The application owns a users table, as in the README, which the
+	// packages' tables must not collide with.
+	app := fstest.MapFS{
+		"migrations/20270101000000_create_widgets.sql": {Data: []byte(
+			"-- +goose Up\nCREATE TABLE IF NOT EXISTS widgets (id INT);\n" +
+				"CREATE TABLE IF NOT EXISTS users (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), email TEXT UNIQUE NOT NULL);\n" +
+				"-- +goose Down\nDROP TABLE IF EXISTS users;\nDROP TABLE IF EXISTS widgets;\n",
+		)},
+	}
+
+	packages := []struct {
+		fs    fs.FS
+		dir   string
+		table string
+	}{
+		{idempotency.Migrations, idempotency.MigrationsDir, idempotency.MigrationsTable},
+		{flags.Migrations, flags.MigrationsDir, flags.MigrationsTable},
+		{session.Migrations, session.MigrationsDir, session.MigrationsTable},
+		{users.Migrations, users.MigrationsDir, users.MigrationsTable},
+		{audit.Migrations, audit.MigrationsDir, audit.MigrationsTable},
+		{apikey.Migrations, apikey.MigrationsDir, apikey.MigrationsTable},
+	}
+	slices.Reverse(packages)
+
+	// The application's later-dated migration runs first, then the packages
+	// newest to oldest; twice, to check re-runs are no-ops.
+	for range 2 {
+		if err := pg.Migrate(db, app, "migrations"); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packages {
+			if err := pg.Migrate(db, p.fs, p.dir, pg.WithMigrationsTable(p.table)); err != nil {
+				t.Fatalf("%s: %v", p.table, err)
+			}
+		}
+	}
+
+	for _, table := range []string{"widgets", "idempotency_keys", "feature_flags", "sessions", "users", "auth_users", "audit_events", "api_keys"} {
+		var exists bool
+		if err := db.Pool.QueryRow(context.Background(), `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil || !exists {
+			t.Errorf("table %s missing: %v", table, err)
+		}
+	}
+}
+
+// TestMigrateOutOfOrder needs a disposable database in TEST_DATABASE_URL.
+func TestMigrateOutOfOrder(t *testing.T) {
+	db := testDB(t)
+
+	table := fmt.Sprintf("out_of_order_goose_db_version_%d", time.Now().UnixNano())
+	t.Cleanup(func() { db.Pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table) })
+
+	newer := fstest.MapFS{
+		"migrations/20270102000000_newer.sql": {Data: []byte("-- +goose Up\nSELECT 1;\n")},
+	}
+	both := fstest.MapFS{
+		"migrations/20270101000000_older.sql": {Data: []byte("-- +goose Up\nSELECT 1;\n")},
+		"migrations/20270102000000_newer.sql": newer["migrations/20270102000000_newer.sql"],
+	}
+
+	if err := pg.Migrate(db, newer, "migrations", pg.WithMigrationsTable(table)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.Migrate(db, both, "migrations", pg.WithMigrationsTable(table)); err == nil {
+		t.Error("older migration applied without WithOutOfOrder")
+	}
+	if err := pg.Migrate(db, both, "migrations", pg.WithMigrationsTable(table), pg.WithOutOfOrder()); err != nil {
+		t.Errorf("WithOutOfOrder: %v", err)
+	}
+}
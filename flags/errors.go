@@ -0,0 +1,11 @@
+package flags
+
+import "errors"
+
+var (
+	ErrNilSource      = errors.New("source cannot be nil")
+	ErrNilLogger      = errors.New("logger cannot be nil")
+	ErrNilDatabase    = errors.New("database cannot be nil")
+	ErrPathRequired   = errors.New("path required")
+	ErrBucketRequired = errors.New("bucket name required")
+)
@@ -0,0 +1,190 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/server"
+)
+
+// Source provides flag definitions. Watch blocks until ctx is cancelled,
+// calling update with the full set of definitions whenever they change.
+type Source interface {
+	Load(ctx context.Context) (map[string]Definition, error)
+	Watch(ctx context.Context, update func(map[string]Definition)) error
+}
+
+type Evaluator struct {
+	cfg    config
+	defs   atomic.Pointer[map[string]Definition]
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(src Source, opts ...Option) (*Evaluator, error) {
+	cfg := config{
+		log:       slog.Default(),
+		subject:   subjectFromAuth0,
+		logLevel:  slog.LevelDebug,
+		logEvents: true,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if src == nil {
+		return nil, ErrNilSource
+	}
+	if cfg.log == nil {
+		return nil, ErrNilLogger
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defs, err := src.Load(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	e := &Evaluator{cfg: cfg, cancel: cancel, done: make(chan struct{})}
+	e.set(defs)
+
+	go func() {
+		defer close(e.done)
+		if err := src.Watch(ctx, e.set); err != nil && ctx.Err() == nil {
+			e.cfg.log.Error("feature flag source stopped", "error", err)
+		}
+	}()
+
+	return e, nil
+}
+
+func (e *Evaluator) Close() {
+	e.cancel()
+	<-e.done
+}
+
+// set stores a copy of defs; sources may keep using the map they pass in.
+func (e *Evaluator) set(defs map[string]Definition) {
+	named := make(map[string]Definition, len(defs))
+	for name, d := range defs {
+		d.Name = name
+		named[name] = d
+	}
+	e.defs.Store(&named)
+	e.cfg.log.Info("feature flags loaded", "count", len(defs))
+}
+
+// For returns an evaluation bound to subject, for use outside HTTP handlers
+// such as in workers.
+func (e *Evaluator) For(s Subject) *Evaluation {
+	return &Evaluation{evaluator: e, subject: &s, values: make(map[string]any)}
+}
+
+// Context returns ctx carrying an evaluation for subject.
+func (e *Evaluator) Context(ctx context.Context, s Subject) context.Context {
+	return context.WithValue(ctx, evaluationKey{}, e.For(s))
+}
+
+func (e *Evaluator) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The subject is resolved from the context passed to Flag.Get, so
+			// this middleware may run before the auth middleware sets the user.
+			ev := &Evaluation{
+				evaluator: e,
+				log:       server.LoggerFromContext(r.Context()),
+				values:    make(map[string]any),
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), evaluationKey{}, ev)))
+
+			if e.cfg.logEvents {
+				if values := ev.Values(); len(values) > 0 {
+					ev.log.Log(r.Context(), e.cfg.logLevel, "feature flags evaluated", "flags", values)
+				}
+			}
+		})
+	}
+}
+
+type evaluationKey struct{}
+
+func FromContext(ctx context.Context) (*Evaluation, bool) {
+	ev, ok := ctx.Value(evaluationKey{}).(*Evaluation)
+	return ev, ok
+}
+
+// Evaluation evaluates flags for one subject and remembers the results.
+type Evaluation struct {
+	evaluator *Evaluator
+	subject   *Subject
+	log       *slog.Logger
+
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// Subject returns the subject flags are evaluated for in ctx.
+func (ev *Evaluation) Subject(ctx context.Context) Subject {
+	if ev.subject != nil {
+		return *ev.subject
+	}
+	return ev.evaluator.cfg.subject(ctx)
+}
+
+// Values returns the flags evaluated so far and their results.
+func (ev *Evaluation) Values() map[string]any {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	out := make(map[string]any, len(ev.values))
+	for k, v := range ev.values {
+		out[k] = v
+	}
+	return out
+}
+
+func (ev *Evaluation) raw(ctx context.Context, name string) json.RawMessage {
+	defs := ev.evaluator.defs.Load()
+	if defs == nil {
+		return nil
+	}
+
+	d, ok := (*defs)[name]
+	if !ok {
+		return nil
+	}
+
+	return d.evaluate(ev.Subject(ctx))
+}
+
+func (ev *Evaluation) record(name string, v any) {
+	ev.mu.Lock()
+	ev.values[name] = v
+	ev.mu.Unlock()
+}
+
+func (ev *Evaluation) logger() *slog.Logger {
+	if ev.log != nil {
+		return ev.log
+	}
+	return ev.evaluator.cfg.log
+}
+
+func subjectFromAuth0(ctx context.Context) Subject {
+	user, ok := auth0.UserFromContext(ctx)
+	if !ok {
+		return Subject{}
+	}
+
+	claims, _ := user.CustomClaims()
+	return Subject{Sub: user.Sub, Email: user.Email, Claims: claims}
+}
@@ -0,0 +1,167 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// Definition is the stored configuration of a single flag. Rules are evaluated
+// in order and the first matching rule's value wins; otherwise Default is used.
+// A disabled definition always yields the flag's code default.
+type Definition struct {
+	Name     string          `json:"name"`
+	Disabled bool            `json:"disabled,omitempty"`
+	Default  json.RawMessage `json:"default,omitempty"`
+	Rules    []Rule          `json:"rules,omitempty"`
+}
+
+// Rule matches a subject when every condition that is set holds. Emails
+// starting with "@" match a whole domain. Percentage (0-100) selects a stable
+// bucket of subjects derived from the flag name and subject sub; anonymous
+// subjects have no stable identity and never match it.
+type Rule struct {
+	Subs       []string        `json:"subs,omitempty"`
+	Emails     []string        `json:"emails,omitempty"`
+	Claims     map[string]any  `json:"claims,omitempty"`
+	Percentage *float64        `json:"percentage,omitempty"`
+	Value      json.RawMessage `json:"value"`
+}
+
+// Subject is who a flag is evaluated for.
+type Subject struct {
+	Sub    string
+	Email  string
+	Claims map[string]any
+}
+
+// Flag is a typed flag declared in code. The value stored in a Definition is
+// decoded into T; decoding failures fall back to the code default.
+type Flag[T any] struct {
+	name string
+	def  T
+}
+
+func Define[T any](name string, def T) *Flag[T] {
+	return &Flag[T]{name: name, def: def}
+}
+
+func Bool(name string, def bool) *Flag[bool]       { return Define(name, def) }
+func String(name string, def string) *Flag[string] { return Define(name, def) }
+func Int(name string, def int) *Flag[int]          { return Define(name, def) }
+func Float(name string, def float64) *Flag[float64] {
+	return Define(name, def)
+}
+
+func (f *Flag[T]) Name() string { return f.name }
+
+// Get evaluates the flag for the request evaluation stored in ctx, returning
+// the code default when there is none.
+func (f *Flag[T]) Get(ctx context.Context) T {
+	ev, ok := FromContext(ctx)
+	if !ok {
+		return f.def
+	}
+
+	raw := ev.raw(ctx, f.name)
+	if raw == nil {
+		ev.record(f.name, f.def)
+		return f.def
+	}
+
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		ev.logger().Warn("invalid feature flag value", "flag", f.name, "error", err)
+		ev.record(f.name, f.def)
+		return f.def
+	}
+
+	ev.record(f.name, v)
+	return v
+}
+
+// Enabled is shorthand for boolean flags.
+func (f *Flag[T]) Enabled(ctx context.Context) bool {
+	v, ok := any(f.Get(ctx)).(bool)
+	return ok && v
+}
+
+func (d *Definition) evaluate(s Subject) json.RawMessage {
+	if d.Disabled {
+		return nil
+	}
+
+	for _, rule := range d.Rules {
+		if rule.matches(d.Name, s) {
+			return rule.Value
+		}
+	}
+
+	return d.Default
+}
+
+func (r *Rule) matches(flag string, s Subject) bool {
+	if len(r.Subs) > 0 && !slices.Contains(r.Subs, s.Sub) {
+		return false
+	}
+
+	if len(r.Emails) > 0 && !matchEmail(r.Emails, s.Email) {
+		return false
+	}
+
+	for k, want := range r.Claims {
+		if !matchClaim(s.Claims[k], want) {
+			return false
+		}
+	}
+
+	if r.Percentage != nil && (s.Sub == "" || bucket(flag, s.Sub) >= *r.Percentage) {
+		return false
+	}
+
+	return true
+}
+
+func matchEmail(patterns []string, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	email = strings.ToLower(email)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if strings.HasPrefix(p, "@") && strings.HasSuffix(email, p) {
+			return true
+		}
+		if p == email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchClaim compares a claim with the wanted value. Array claims such as roles
+// match when they contain the wanted value.
+func matchClaim(got, want any) bool {
+	if list, ok := got.([]any); ok {
+		for _, v := range list {
+			if fmt.Sprint(v) == fmt.Sprint(want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return got != nil && fmt.Sprint(got) == fmt.Sprint(want)
+}
+
+// bucket maps a subject to [0, 100) for percentage rollouts.
+func bucket(flag, sub string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(flag + ":" + sub))
+	return float64(h.Sum32()%10000) / 100
+}
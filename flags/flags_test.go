@@ -0,0 +1,180 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/nats"
+)
+
+func TestEvaluate(t *testing.T) {
+	half, all := 50.0, 100.0
+
+	ev, err := New(Static{
+		"checkout": {
+			Default: json.RawMessage(`"v1"`),
+			Rules: []Rule{
+				{Subs: []string{"auth0|beta"}, Value: json.RawMessage(`"v3"`)},
+				{Emails: []string{"@example.com"}, Claims: map[string]any{"roles": "staff"}, Value: json.RawMessage(`"v2"`)},
+			},
+		},
+		"rollout": {
+			Rules: []Rule{{Percentage: &half, Value: json.RawMessage(`true`)}},
+		},
+		"everyone": {
+			Rules: []Rule{{Percentage: &all, Value: json.RawMessage(`true`)}},
+		},
+		"broken": {Default: json.RawMessage(`"not a number"`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ev.Close()
+
+	checkout := String("checkout", "v0")
+	limit := Int("broken", 7)
+
+	tests := []struct {
+		name    string
+		subject Subject
+		want    string
+	}{
+		{name: "sub rule", subject: Subject{Sub: "auth0|beta"}, want: "v3"},
+		{name: "email and claim rule", subject: Subject{Email: "A@Example.com", Claims: map[string]any{"roles": []any{"staff"}}}, want: "v2"},
+		{name: "email without claim", subject: Subject{Email: "a@example.com"}, want: "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ev.Context(context.Background(), tt.subject)
+			if got := checkout.Get(ctx); got != tt.want {
+				t.Errorf("checkout = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := checkout.Get(context.Background()); got != "v0" {
+		t.Errorf("without evaluator got %q, want code default", got)
+	}
+	if got := limit.Get(ev.Context(context.Background(), Subject{})); got != 7 {
+		t.Errorf("invalid value should fall back to default, got %d", got)
+	}
+
+	rollout := Bool("rollout", false)
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		if rollout.Enabled(ev.Context(context.Background(), Subject{Sub: string(rune('a'+i%26)) + string(rune(i))})) {
+			enabled++
+		}
+	}
+	if enabled < 400 || enabled > 600 {
+		t.Errorf("50%% rollout enabled %d of 1000 subjects", enabled)
+	}
+
+	everyone := Bool("everyone", false)
+	if !everyone.Enabled(ev.Context(context.Background(), Subject{Sub: "auth0|1"})) {
+		t.Error("100% rollout should enable signed-in users")
+	}
+	if everyone.Enabled(ev.Context(context.Background(), Subject{})) {
+		t.Error("anonymous subjects should be excluded from percentage rollouts")
+	}
+}
+
+func TestMiddlewareUsesAuth0User(t *testing.T) {
+	ev, err := New(Static{
+		"beta": {Rules: []Rule{{Subs: []string{"auth0|1"}, Value: json.RawMessage(`true`)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ev.Close()
+
+	beta := Bool("beta", false)
+
+	var got bool
+	h := ev.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulates the auth middleware running after the flags middleware.
+		ctx := auth0.ContextWithUser(r.Context(), auth0.SessionUser{Sub: "auth0|1"})
+		got = beta.Enabled(ctx)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !got {
+		t.Error("beta should be enabled for auth0|1")
+	}
+}
+
+func TestEvaluatorCopiesDefinitions(t *testing.T) {
+	defs := Static{"beta": {Default: json.RawMessage(`true`)}}
+
+	ev, err := New(defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ev.Close()
+
+	if defs["beta"].Name != "" {
+		t.Error("New modified the source's map")
+	}
+}
+
+func TestKVSource(t *testing.T) {
+	nc, shutdown, err := nats.New(nats.WithJetStream(true, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := NewKVSource(js, "flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	if err = src.Set(ctx, Definition{Name: "beta", Default: json.RawMessage(`true`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan map[string]Definition, 4)
+	go src.Watch(ctx, func(defs map[string]Definition) { updates <- defs })
+
+	next := func() map[string]Definition {
+		t.Helper()
+		select {
+		case defs := <-updates:
+			return defs
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update from Watch")
+			return nil
+		}
+	}
+
+	if defs := next(); string(defs["beta"].Default) != "true" {
+		t.Errorf("initial update = %v, want beta from Set", defs)
+	}
+
+	if err = src.Set(ctx, Definition{Name: "beta", Default: json.RawMessage(`false`)}); err != nil {
+		t.Fatal(err)
+	}
+	if defs := next(); string(defs["beta"].Default) != "false" {
+		t.Errorf("watched update = %v, want beta false", defs)
+	}
+
+	cancel()
+	if err = src.Set(ctx, Definition{Name: "beta"}); err == nil {
+		t.Error("Set with a cancelled context should fail")
+	}
+}
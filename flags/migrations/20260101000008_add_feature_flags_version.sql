@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS feature_flags_version (
+    id      BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    version BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO feature_flags_version (id) VALUES (TRUE) ON CONFLICT DO NOTHING;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION feature_flags_bump_version() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE feature_flags_version SET version = version + 1;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER feature_flags_version_trigger
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON feature_flags
+    FOR EACH STATEMENT EXECUTE FUNCTION feature_flags_bump_version();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS feature_flags_version_trigger ON feature_flags;
+DROP FUNCTION IF EXISTS feature_flags_bump_version();
+DROP TABLE IF EXISTS feature_flags_version;
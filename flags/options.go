@@ -0,0 +1,34 @@
+package flags
+
+import (
+	"context"
+	"log/slog"
+)
+
+type Option func(*config)
+
+type config struct {
+	log       *slog.Logger
+	subject   func(context.Context) Subject
+	logLevel  slog.Level
+	logEvents bool
+}
+
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
+
+// WithSubject overrides how the evaluation subject is derived from a request
+// context. The default uses the auth0 user in the context.
+func WithSubject(fn func(context.Context) Subject) Option {
+	return func(c *config) { c.subject = fn }
+}
+
+// WithEvaluationLogging controls whether the middleware logs the flags that
+// were evaluated during each request, and at which level.
+func WithEvaluationLogging(enabled bool, level slog.Level) Option {
+	return func(c *config) {
+		c.logEvents = enabled
+		c.logLevel = level
+	}
+}
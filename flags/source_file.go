@@ -0,0 +1,71 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// FileSource reads definitions from a JSON file mapping flag names to
+// definitions, reloading it when its modification time changes.
+type FileSource struct {
+	path     string
+	interval time.Duration
+}
+
+func NewFileSource(path string, interval time.Duration) (*FileSource, error) {
+	if path == "" {
+		return nil, ErrPathRequired
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &FileSource{path: path, interval: interval}, nil
+}
+
+func (s *FileSource) Load(context.Context) (map[string]Definition, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make(map[string]Definition)
+	if err = json.Unmarshal(b, &defs); err != nil {
+		return nil, err
+	}
+
+	return defs, nil
+}
+
+func (s *FileSource) Watch(ctx context.Context, update func(map[string]Definition)) error {
+	var last time.Time
+	if fi, err := os.Stat(s.path); err == nil {
+		last = fi.ModTime()
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(s.path)
+		if err != nil || !fi.ModTime().After(last) {
+			continue
+		}
+
+		defs, err := s.Load(ctx)
+		if err != nil {
+			// Keep serving the previous definitions until the file is valid again.
+			continue
+		}
+
+		last = fi.ModTime()
+		update(defs)
+	}
+}
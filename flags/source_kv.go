@@ -0,0 +1,117 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/nats-io/nats.go"
+)
+
+// KVSource reads definitions from a JetStream key/value bucket where each key
+// is a flag name and each value a JSON Definition. Changes are pushed as they
+// happen.
+type KVSource struct {
+	kv nats.KeyValue
+}
+
+func NewKVSource(js nats.JetStreamContext, bucket string) (*KVSource, error) {
+	if bucket == "" {
+		return nil, ErrBucketRequired
+	}
+
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVSource{kv: kv}, nil
+}
+
+func (s *KVSource) Load(ctx context.Context) (map[string]Definition, error) {
+	w, err := s.kv.WatchAll(nats.IgnoreDeletes(), nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Stop()
+
+	defs := make(map[string]Definition)
+	for entry := range w.Updates() {
+		if entry == nil {
+			break
+		}
+		var d Definition
+		if err = json.Unmarshal(entry.Value(), &d); err != nil {
+			return nil, err
+		}
+		defs[entry.Key()] = d
+	}
+
+	return defs, nil
+}
+
+func (s *KVSource) Watch(ctx context.Context, update func(map[string]Definition)) error {
+	w, err := s.kv.WatchAll(nats.UpdatesOnly(), nats.Context(ctx))
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	// Reload after the watch starts, so changes made since the evaluator's
+	// initial Load are not missed.
+	defs, err := s.Load(ctx)
+	if err != nil {
+		return err
+	}
+	update(defs)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-w.Updates():
+			if !ok {
+				return nil
+			}
+			if entry == nil {
+				continue
+			}
+
+			next := make(map[string]Definition, len(defs))
+			for k, v := range defs {
+				next[k] = v
+			}
+
+			switch entry.Operation() {
+			case nats.KeyValuePut:
+				var d Definition
+				if err := json.Unmarshal(entry.Value(), &d); err != nil {
+					continue
+				}
+				next[entry.Key()] = d
+			default:
+				delete(next, entry.Key())
+			}
+
+			defs = next
+			update(next)
+		}
+	}
+}
+
+// Set creates or replaces a flag definition.
+func (s *KVSource) Set(ctx context.Context, d Definition) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = s.kv.Put(d.Name, raw)
+	return err
+}
@@ -0,0 +1,125 @@
+package flags
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"time"
+
+	"github.com/derekmwright/web/database/pg"
+)
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const (
+	MigrationsDir   = "migrations"
+	MigrationsTable = "flags_goose_db_version"
+)
+
+// PGSource reads definitions from the feature_flags table, polling a version
+// counter that a trigger bumps on every committed write. Apply the schema with
+// pg.Migrate using Migrations, MigrationsDir and MigrationsTable.
+type PGSource struct {
+	db       *pg.Database
+	interval time.Duration
+}
+
+func NewPGSource(db *pg.Database, interval time.Duration) (*PGSource, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &PGSource{db: db, interval: interval}, nil
+}
+
+func (s *PGSource) Load(ctx context.Context) (map[string]Definition, error) {
+	rows, err := s.db.Pool.Query(ctx, `SELECT name, definition FROM feature_flags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defs := make(map[string]Definition)
+	for rows.Next() {
+		var (
+			name string
+			raw  []byte
+			d    Definition
+		)
+		if err = rows.Scan(&name, &raw); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		defs[name] = d
+	}
+
+	return defs, rows.Err()
+}
+
+func (s *PGSource) Watch(ctx context.Context, update func(map[string]Definition)) error {
+	// Reload once the version is known, so writes made since the evaluator's
+	// initial Load are not missed.
+	version, err := s.version(ctx)
+	if err == nil {
+		var defs map[string]Definition
+		if defs, err = s.Load(ctx); err == nil {
+			update(defs)
+		}
+	}
+	if err != nil {
+		version = -1 // reload on the first successful poll
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		v, err := s.version(ctx)
+		if err != nil || v == version {
+			continue
+		}
+
+		defs, err := s.Load(ctx)
+		if err != nil {
+			continue
+		}
+
+		version = v
+		update(defs)
+	}
+}
+
+// Set creates or replaces a flag definition.
+func (s *PGSource) Set(ctx context.Context, d Definition) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Pool.Exec(ctx, `
+		INSERT INTO feature_flags (name, definition, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET definition = EXCLUDED.definition, updated_at = NOW()`,
+		d.Name, raw,
+	)
+	return err
+}
+
+// version changes whenever rows are inserted, updated or deleted. It is read
+// before Load, so a write committed in between is picked up on the next poll.
+func (s *PGSource) version(ctx context.Context) (int64, error) {
+	var v int64
+	err := s.db.Pool.QueryRow(ctx, `SELECT version FROM feature_flags_version`).Scan(&v)
+	return v, err
+}
@@ -0,0 +1,19 @@
+package flags
+
+import "context"
+
+// Static is a fixed set of definitions, useful in tests.
+type Static map[string]Definition
+
+func (s Static) Load(context.Context) (map[string]Definition, error) {
+	defs := make(map[string]Definition, len(s))
+	for k, v := range s {
+		defs[k] = v
+	}
+	return defs, nil
+}
+
+func (s Static) Watch(ctx context.Context, _ func(map[string]Definition)) error {
+	<-ctx.Done()
+	return nil
+}
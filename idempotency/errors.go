@@ -0,0 +1,13 @@
+package idempotency
+
+import "errors"
+
+var (
+	ErrNilStore        = errors.New("store cannot be nil")
+	ErrNilLogger       = errors.New("logger cannot be nil")
+	ErrNilDatabase     = errors.New("database cannot be nil")
+	ErrBucketRequired  = errors.New("bucket name required")
+	ErrRecordNotFound  = errors.New("idempotency record not found")
+	ErrRequestTooLarge = errors.New("request body too large to fingerprint")
+	ErrReservationLost = errors.New("idempotency key reservation expired and was taken over")
+)
@@ -0,0 +1,244 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/derekmwright/web/auth/auth0"
+)
+
+const ReplayedHeader = "Idempotent-Replayed"
+
+// clientHeaders are response headers that belong to the client that made the
+// original request, such as its session cookie, and are neither stored nor
+// replayed.
+var clientHeaders = []string{"Set-Cookie", "Date", "Connection", "Keep-Alive", ReplayedHeader}
+
+type Record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Completed   bool        `json:"completed"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	ExpiresAt   time.Time   `json:"expires_at"`
+	// Token identifies the request holding the reservation.
+	Token string `json:"token,omitempty"`
+}
+
+// Store persists idempotency records.
+//
+// Reserve atomically claims key for the request identified by token until ttl,
+// the lock timeout, passes. When the key is already held by an unexpired
+// record, that record is returned with reserved set to false. Complete
+// replaces the reservation with the response and the record's own ExpiresAt;
+// Release deletes it. Both only act while token still holds the key, so a
+// request whose lock timed out cannot touch a newer reservation: Complete
+// returns ErrReservationLost and Release does nothing.
+type Store interface {
+	Reserve(ctx context.Context, key, fingerprint, token string, ttl time.Duration) (existing *Record, reserved bool, err error)
+	Complete(ctx context.Context, key, token string, rec *Record) error
+	Release(ctx context.Context, key, token string) error
+}
+
+func New(store Store, opts ...Option) (func(http.Handler) http.Handler, error) {
+	cfg := config{
+		header:      "Idempotency-Key",
+		ttl:         24 * time.Hour,
+		lockTimeout: time.Minute,
+		maxBodySize: 1 << 20,
+		methods: map[string]bool{
+			http.MethodPost:   true,
+			http.MethodPut:    true,
+			http.MethodPatch:  true,
+			http.MethodDelete: true,
+		},
+		keyFunc: UserKey,
+		log:     slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if store == nil {
+		return nil, ErrNilStore
+	}
+	if cfg.log == nil {
+		return nil, ErrNilLogger
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.Header.Get(cfg.header)
+			if key == "" {
+				if cfg.required {
+					http.Error(w, cfg.header+" header required", http.StatusBadRequest)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key = cfg.keyFunc(r, key)
+
+			fingerprint, body, err := fingerprintRequest(r, cfg.maxBodySize)
+			if err != nil {
+				if errors.Is(err, ErrRequestTooLarge) {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				cfg.log.Error("unable to read request body", "error", err)
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			token := rand.Text()
+			existing, reserved, err := store.Reserve(r.Context(), key, fingerprint, token, cfg.lockTimeout)
+			if err != nil {
+				cfg.log.Error("unable to reserve idempotency key", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			if !reserved {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					http.Error(w, cfg.header+" was already used with a different request", http.StatusConflict)
+				case !existing.Completed:
+					http.Error(w, "a request with this "+cfg.header+" is already in progress", http.StatusConflict)
+				default:
+					replay(w, existing)
+				}
+				return
+			}
+
+			rec := &capture{ResponseWriter: w, status: http.StatusOK}
+
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				// Use a fresh context so a cancelled request still frees the key.
+				if err := store.Release(context.WithoutCancel(r.Context()), key, token); err != nil {
+					cfg.log.Error("unable to release idempotency key", "error", err)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= http.StatusInternalServerError {
+				return
+			}
+
+			err = store.Complete(context.WithoutCancel(r.Context()), key, token, &Record{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				Status:      rec.status,
+				Header:      storedHeader(rec.Header()),
+				Body:        rec.body.Bytes(),
+				ExpiresAt:   time.Now().Add(cfg.ttl),
+			})
+			if err != nil {
+				cfg.log.Error("unable to store idempotent response", "error", err)
+				return
+			}
+			completed = true
+		})
+	}, nil
+}
+
+func fingerprintRequest(r *http.Request, limit int64) (string, []byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, limit+1))
+		if err != nil {
+			return "", nil, err
+		}
+		if int64(len(body)) > limit {
+			return "", nil, ErrRequestTooLarge
+		}
+	}
+
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	h.Write([]byte{0})
+	io.WriteString(h, r.URL.RequestURI())
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil)), body, nil
+}
+
+// UserKey scopes key to the authenticated auth0 user, so clients cannot
+// replay each other's responses. Anonymous requests share one scope. It is the
+// default key function.
+func UserKey(r *http.Request, key string) string {
+	user, ok := auth0.UserFromContext(r.Context())
+	if !ok {
+		return "anonymous:" + key
+	}
+	// The length prefix keeps a sub containing ":" from forging another scope.
+	return "user:" + strconv.Itoa(len(user.Sub)) + ":" + user.Sub + ":" + key
+}
+
+func storedHeader(h http.Header) http.Header {
+	stored := h.Clone()
+	for _, k := range clientHeaders {
+		stored.Del(k)
+	}
+	return stored
+}
+
+func replay(w http.ResponseWriter, rec *Record) {
+	for k, v := range storedHeader(rec.Header) {
+		w.Header()[k] = v
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+type capture struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *capture) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *capture) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *capture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,229 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/nats"
+)
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	inFlight := false
+
+	mw, err := New(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h http.Handler
+	h = mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if inFlight {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"id":1}`))
+			req.Header.Set("Idempotency-Key", "abc")
+			inFlight = false
+			h.ServeHTTP(rr, req)
+			if rr.Code != http.StatusConflict {
+				t.Errorf("in-flight duplicate status = %d, want %d", rr.Code, http.StatusConflict)
+			}
+		}
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	tests := []struct {
+		name         string
+		key          string
+		body         string
+		inFlight     bool
+		wantStatus   int
+		wantReplayed bool
+		wantCalls    int
+	}{
+		{name: "first request runs handler", key: "abc", body: `{"id":1}`, inFlight: true, wantStatus: http.StatusCreated, wantCalls: 1},
+		{name: "retry replays response", key: "abc", body: `{"id":1}`, wantStatus: http.StatusCreated, wantReplayed: true, wantCalls: 1},
+		{name: "mismatched body conflicts", key: "abc", body: `{"id":2}`, wantStatus: http.StatusConflict, wantCalls: 1},
+		{name: "missing key passes through", body: `{"id":1}`, wantStatus: http.StatusCreated, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlight = tt.inFlight
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/orders", strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get(ReplayedHeader) == "true"; got != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", got, tt.wantReplayed)
+			}
+			if tt.wantReplayed && (rr.Body.String() != "created" || rr.Header().Get("Location") != "/orders/1") {
+				t.Errorf("replayed response mismatch: %q %v", rr.Body.String(), rr.Header())
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	store := NewMemoryStore()
+	mw, err := New(store, WithLockTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reservedUntil time.Time
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservedUntil = store.records["anonymous:abc"].ExpiresAt
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Idempotency-Key", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if time.Until(reservedUntil) > 10*time.Millisecond {
+		t.Errorf("reservation held until %v, want lock timeout", reservedUntil)
+	}
+	if time.Until(store.records["anonymous:abc"].ExpiresAt) < time.Hour {
+		t.Errorf("completed record expires at %v, want TTL", store.records["anonymous:abc"].ExpiresAt)
+	}
+
+	// A reservation abandoned by a crashed request is reclaimable once the
+	// lock timeout passes.
+	store.Reserve(req.Context(), "stale", "fp", "first", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, reserved, _ := store.Reserve(req.Context(), "stale", "fp", "second", time.Minute); !reserved {
+		t.Error("stale reservation was not reclaimed")
+	}
+
+	// The timed-out request must not touch the reservation that replaced it.
+	store.Release(req.Context(), "stale", "first")
+	if _, ok := store.records["stale"]; !ok {
+		t.Error("stale request released the new reservation")
+	}
+	if err := store.Complete(req.Context(), "stale", "first", &Record{Completed: true}); err != ErrReservationLost {
+		t.Errorf("stale Complete = %v, want ErrReservationLost", err)
+	}
+	if err := store.Complete(req.Context(), "stale", "second", &Record{Completed: true}); err != nil {
+		t.Errorf("Complete = %v", err)
+	}
+}
+
+func TestReplayIsolation(t *testing.T) {
+	mw, err := New(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "first-client"})
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	tests := []struct {
+		name         string
+		sub          string
+		wantReplayed bool
+		wantCalls    int
+	}{
+		{name: "first request", sub: "auth0|alice", wantCalls: 1},
+		{name: "same user replays", sub: "auth0|alice", wantReplayed: true, wantCalls: 1},
+		{name: "other user runs handler", sub: "auth0|bob", wantCalls: 2},
+		{name: "anonymous runs handler", wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"id":1}`))
+			req.Header.Set("Idempotency-Key", "abc")
+			if tt.sub != "" {
+				req = req.WithContext(auth0.ContextWithUser(req.Context(), auth0.SessionUser{Sub: tt.sub}))
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			replayed := rr.Header().Get(ReplayedHeader) == "true"
+			if replayed != tt.wantReplayed || calls != tt.wantCalls {
+				t.Errorf("replayed = %v, calls = %d; want %v, %d", replayed, calls, tt.wantReplayed, tt.wantCalls)
+			}
+			if replayed && (rr.Header().Get("Set-Cookie") != "" || rr.Header().Get("Location") != "/orders/1") {
+				t.Errorf("replayed headers = %v, want Location without Set-Cookie", rr.Header())
+			}
+		})
+	}
+}
+
+func TestKVStoreToken(t *testing.T) {
+	nc, shutdown, err := nats.New(nats.WithJetStream(true, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewKVStore(js, "idempotency", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.Context()
+	if _, reserved, err := store.Reserve(ctx, "k", "fp", "first", 10*time.Millisecond); err != nil || !reserved {
+		t.Fatalf("Reserve = %v, %v", reserved, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, reserved, err := store.Reserve(ctx, "k", "fp", "second", time.Minute); err != nil || !reserved {
+		t.Fatalf("reclaim = %v, %v", reserved, err)
+	}
+
+	if err = store.Release(ctx, "k", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if existing, reserved, _ := store.Reserve(ctx, "k", "fp", "third", time.Minute); reserved || existing.Token != "second" {
+		t.Error("stale request released the new reservation")
+	}
+	if err = store.Complete(ctx, "k", "first", &Record{Completed: true}); err != ErrReservationLost {
+		t.Errorf("stale Complete = %v, want ErrReservationLost", err)
+	}
+	if err = store.Complete(ctx, "k", "second", &Record{Completed: true, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Errorf("Complete = %v", err)
+	}
+
+	// A completed record survives its own request's Release.
+	if err = store.Release(ctx, "k", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if existing, _, _ := store.Reserve(ctx, "k", "fp", "third", time.Minute); existing == nil || !existing.Completed {
+		t.Error("Release removed a completed record")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err = store.Reserve(cancelled, "other", "fp", "t", time.Minute); err != context.Canceled {
+		t.Errorf("Reserve with cancelled ctx = %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// KVStore stores records in a JetStream key/value bucket. Each record's
+// ExpiresAt, from the lock timeout or WithTTL, is enforced on read. The bucket
+// TTL given to NewKVStore only bounds storage, so it should be at least
+// WithTTL; an existing bucket keeps the TTL it was created with.
+type KVStore struct {
+	kv nats.KeyValue
+}
+
+func NewKVStore(js nats.JetStreamContext, bucket string, ttl time.Duration) (*KVStore, error) {
+	if bucket == "" {
+		return nil, ErrBucketRequired
+	}
+
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket: bucket,
+			TTL:    ttl,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVStore{kv: kv}, nil
+}
+
+func (s *KVStore) Reserve(ctx context.Context, key, fingerprint, token string, ttl time.Duration) (*Record, bool, error) {
+	k := kvKey(key)
+
+	data, err := json.Marshal(&Record{Fingerprint: fingerprint, ExpiresAt: time.Now().Add(ttl), Token: token})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for {
+		// The KeyValue API takes no context, so honour cancellation between
+		// attempts.
+		if err = ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		_, err = s.kv.Create(k, data)
+		if err == nil {
+			return nil, true, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return nil, false, err
+		}
+
+		entry, err := s.kv.Get(k)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		var rec Record
+		if err = json.Unmarshal(entry.Value(), &rec); err != nil {
+			return nil, false, err
+		}
+
+		if time.Now().Before(rec.ExpiresAt) {
+			return &rec, false, nil
+		}
+
+		if _, err = s.kv.Update(k, data, entry.Revision()); err == nil {
+			return nil, true, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return nil, false, err
+		}
+	}
+}
+
+func (s *KVStore) Complete(ctx context.Context, key, token string, rec *Record) error {
+	k := kvKey(key)
+
+	entry, cur, err := s.get(k)
+	if err != nil {
+		return err
+	}
+	if entry == nil || cur.Token != token {
+		return ErrReservationLost
+	}
+
+	stored := *rec
+	stored.Token = token
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Update(k, data, entry.Revision())
+	if errors.Is(err, nats.ErrKeyExists) {
+		return ErrReservationLost
+	}
+	return err
+}
+
+func (s *KVStore) Release(ctx context.Context, key, token string) error {
+	k := kvKey(key)
+
+	entry, cur, err := s.get(k)
+	if err != nil || entry == nil || cur.Token != token || cur.Completed {
+		return err
+	}
+
+	// Another request may have taken the key over since the read.
+	err = s.kv.Delete(k, nats.LastRevision(entry.Revision()))
+	if errors.Is(err, nats.ErrKeyExists) {
+		return nil
+	}
+	return err
+}
+
+// get returns the entry and record stored at k, or a nil entry if there is
+// none.
+func (s *KVStore) get(k string) (nats.KeyValueEntry, *Record, error) {
+	entry, err := s.kv.Get(k)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rec Record
+	if err = json.Unmarshal(entry.Value(), &rec); err != nil {
+		return nil, nil, err
+	}
+	return entry, &rec, nil
+}
+
+// kvKey hashes client keys since they may contain characters that are not
+// valid in KV key names.
+func kvKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
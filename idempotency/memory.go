@@ -0,0 +1,54 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in process. It is suitable for tests and single
+// replica deployments only.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+func (m *MemoryStore) Reserve(ctx context.Context, key, fingerprint, token string, ttl time.Duration) (*Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.records[key]; ok && time.Now().Before(rec.ExpiresAt) {
+		return rec, false, nil
+	}
+
+	m.records[key] = &Record{Fingerprint: fingerprint, ExpiresAt: time.Now().Add(ttl), Token: token}
+	return nil, true, nil
+}
+
+func (m *MemoryStore) Complete(ctx context.Context, key, token string, rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.records[key]; !ok || cur.Token != token {
+		return ErrReservationLost
+	}
+
+	stored := *rec
+	stored.Token = token
+	m.records[key] = &stored
+	return nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.records[key]; ok && rec.Token == token && !rec.Completed {
+		delete(m.records, key)
+	}
+	return nil
+}
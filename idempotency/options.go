@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type Option func(*config)
+
+type config struct {
+	header      string
+	ttl         time.Duration
+	lockTimeout time.Duration
+	required    bool
+	maxBodySize int64
+	methods     map[string]bool
+	keyFunc     func(r *http.Request, key string) string
+	log         *slog.Logger
+}
+
+func WithTTL(d time.Duration) Option {
+	return func(c *config) { c.ttl = d }
+}
+
+// WithLockTimeout bounds how long a key stays reserved by a request that has
+// not completed, e.g. because the process crashed mid-request. It must be
+// longer than the slowest handler, or a retry may run concurrently with the
+// original request.
+func WithLockTimeout(d time.Duration) Option {
+	return func(c *config) { c.lockTimeout = d }
+}
+
+func WithHeader(name string) Option {
+	return func(c *config) { c.header = name }
+}
+
+// WithRequired rejects mutating requests that omit the idempotency header with
+// 400 Bad Request instead of passing them through.
+func WithRequired(required bool) Option {
+	return func(c *config) { c.required = required }
+}
+
+func WithMaxBodySize(n int64) Option {
+	return func(c *config) { c.maxBodySize = n }
+}
+
+func WithMethods(methods ...string) Option {
+	return func(c *config) {
+		c.methods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			c.methods[m] = true
+		}
+	}
+}
+
+// WithKeyFunc replaces UserKey, which scopes the client supplied key to the
+// authenticated user, e.g. to scope keys by tenant as well.
+func WithKeyFunc(fn func(r *http.Request, key string) string) Option {
+	return func(c *config) { c.keyFunc = fn }
+}
+
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
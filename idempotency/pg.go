@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/derekmwright/web/database/pg"
+)
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const (
+	MigrationsDir   = "migrations"
+	MigrationsTable = "idempotency_goose_db_version"
+)
+
+// maxReserveAttempts bounds retries of a reservation that keeps losing races
+// with concurrent releases.
+const maxReserveAttempts = 3
+
+// PGStore stores records in the idempotency_keys table. Apply the schema with
+// pg.Migrate using Migrations, MigrationsDir and MigrationsTable.
+type PGStore struct {
+	db *pg.Database
+}
+
+func NewPGStore(db *pg.Database) (*PGStore, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	return &PGStore{db: db}, nil
+}
+
+func (s *PGStore) Reserve(ctx context.Context, key, fingerprint, token string, ttl time.Duration) (*Record, bool, error) {
+	tx, err := s.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= now()`, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		rec    Record
+		header []byte
+	)
+
+	// The conflicting row may expire or be released between the insert and
+	// the select; each statement sees the latest commits, so try again.
+	for attempt := 0; ; attempt++ {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO idempotency_keys (key, fingerprint, expires_at, token)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (key) DO NOTHING`,
+			key, fingerprint, time.Now().Add(ttl), token,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if tag.RowsAffected() == 1 {
+			return nil, true, tx.Commit(ctx)
+		}
+
+		err = tx.QueryRow(ctx, `
+			SELECT fingerprint, completed, status, header, body, expires_at, token
+			FROM idempotency_keys WHERE key = $1`,
+			key,
+		).Scan(&rec.Fingerprint, &rec.Completed, &rec.Status, &header, &rec.Body, &rec.ExpiresAt, &rec.Token)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, err
+		}
+		if attempt == maxReserveAttempts {
+			return nil, false, ErrRecordNotFound
+		}
+	}
+
+	if len(header) > 0 {
+		if err = json.Unmarshal(header, &rec.Header); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &rec, false, tx.Commit(ctx)
+}
+
+func (s *PGStore) Complete(ctx context.Context, key, token string, rec *Record) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+
+	tag, err := s.db.Pool.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET completed = TRUE, status = $3, header = $4, body = $5, expires_at = $6
+		WHERE key = $1 AND token = $2`,
+		key, token, rec.Status, header, rec.Body, rec.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrReservationLost
+	}
+	return nil
+}
+
+func (s *PGStore) Release(ctx context.Context, key, token string) error {
+	_, err := s.db.Pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND token = $2 AND NOT completed`, key, token)
+	return err
+}
+
+// PurgeExpired deletes expired records and returns how many were removed.
+func (s *PGStore) PurgeExpired(ctx context.Context) (int64, error) {
+	tag, err := s.db.Pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
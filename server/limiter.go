@@ -0,0 +1,349 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type LimiterMode int
+
+const (
+	LimiterFixed LimiterMode = iota
+	LimiterAIMD
+	LimiterGradient
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+type LimiterOption func(*limiterConfig)
+
+type limiterConfig struct {
+	mode             LimiterMode
+	initialLimit     int
+	minLimit         int
+	maxLimit         int
+	maxQueue         int
+	queueTimeout     time.Duration
+	retryAfter       time.Duration
+	latencyThreshold time.Duration
+	backoff          float64
+	classify         func(*http.Request) Priority
+	pathPriorities   []pathPriority
+}
+
+type pathPriority struct {
+	prefix   string
+	priority Priority
+}
+
+func WithLimiterMode(m LimiterMode) LimiterOption {
+	return func(c *limiterConfig) { c.mode = m }
+}
+
+func WithLimiterLimit(initial, min, max int) LimiterOption {
+	return func(c *limiterConfig) {
+		c.initialLimit = initial
+		c.minLimit = min
+		c.maxLimit = max
+	}
+}
+
+func WithLimiterQueue(size int, timeout time.Duration) LimiterOption {
+	return func(c *limiterConfig) {
+		c.maxQueue = size
+		c.queueTimeout = timeout
+	}
+}
+
+func WithLimiterRetryAfter(d time.Duration) LimiterOption {
+	return func(c *limiterConfig) { c.retryAfter = d }
+}
+
+// WithLimiterLatencyThreshold sets the latency above which AIMD mode treats a
+// request as a congestion signal and backs off.
+func WithLimiterLatencyThreshold(d time.Duration) LimiterOption {
+	return func(c *limiterConfig) { c.latencyThreshold = d }
+}
+
+func WithLimiterBackoff(ratio float64) LimiterOption {
+	return func(c *limiterConfig) { c.backoff = ratio }
+}
+
+func WithLimiterClassifier(fn func(*http.Request) Priority) LimiterOption {
+	return func(c *limiterConfig) { c.classify = fn }
+}
+
+// WithLimiterPathPriority assigns a priority class to requests whose path starts
+// with prefix. The longest matching prefix wins.
+func WithLimiterPathPriority(prefix string, p Priority) LimiterOption {
+	return func(c *limiterConfig) {
+		c.pathPriorities = append(c.pathPriorities, pathPriority{prefix: prefix, priority: p})
+	}
+}
+
+type LimiterStats struct {
+	Mode       string `json:"mode"`
+	Limit      int    `json:"limit"`
+	InFlight   int    `json:"in_flight"`
+	QueueDepth int    `json:"queue_depth"`
+	Rejected   uint64 `json:"rejected"`
+	Served     uint64 `json:"served"`
+}
+
+type Limiter struct {
+	cfg limiterConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	queues   [PriorityHigh + 1]*list.List
+	queued   int
+	minRTT   time.Duration
+	rttReset time.Time
+
+	rejected atomic.Uint64
+	served   atomic.Uint64
+}
+
+type waiter struct {
+	ready    chan bool
+	priority Priority
+}
+
+func NewLimiter(opts ...LimiterOption) *Limiter {
+	cfg := limiterConfig{
+		mode:             LimiterFixed,
+		initialLimit:     20,
+		minLimit:         1,
+		maxLimit:         200,
+		maxQueue:         50,
+		queueTimeout:     100 * time.Millisecond,
+		retryAfter:       time.Second,
+		latencyThreshold: 250 * time.Millisecond,
+		backoff:          0.9,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.minLimit < 1 {
+		cfg.minLimit = 1
+	}
+	if cfg.maxLimit < cfg.minLimit {
+		cfg.maxLimit = cfg.minLimit
+	}
+	if cfg.initialLimit < cfg.minLimit {
+		cfg.initialLimit = cfg.minLimit
+	}
+	if cfg.initialLimit > cfg.maxLimit {
+		cfg.initialLimit = cfg.maxLimit
+	}
+
+	l := &Limiter{cfg: cfg, limit: float64(cfg.initialLimit)}
+	for i := range l.queues {
+		l.queues[i] = list.New()
+	}
+
+	return l
+}
+
+func (l *Limiter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.acquire(r.Context(), l.priority(r)) {
+				l.rejected.Add(1)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.cfg.retryAfter.Seconds()))))
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				l.release(time.Since(start), ww.Status())
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return LimiterStats{
+		Mode:       l.cfg.mode.String(),
+		Limit:      int(l.limit),
+		InFlight:   l.inflight,
+		QueueDepth: l.queued,
+		Rejected:   l.rejected.Load(),
+		Served:     l.served.Load(),
+	}
+}
+
+func (l *Limiter) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.Stats())
+}
+
+func (m LimiterMode) String() string {
+	switch m {
+	case LimiterAIMD:
+		return "aimd"
+	case LimiterGradient:
+		return "gradient"
+	default:
+		return "fixed"
+	}
+}
+
+func (l *Limiter) priority(r *http.Request) Priority {
+	if l.cfg.classify != nil {
+		return l.cfg.classify(r)
+	}
+
+	p, longest := PriorityNormal, -1
+	for _, pp := range l.cfg.pathPriorities {
+		if strings.HasPrefix(r.URL.Path, pp.prefix) && len(pp.prefix) > longest {
+			p, longest = pp.priority, len(pp.prefix)
+		}
+	}
+
+	return p
+}
+
+func (l *Limiter) acquire(ctx context.Context, p Priority) bool {
+	if p < PriorityLow {
+		p = PriorityLow
+	}
+	if p > PriorityHigh {
+		p = PriorityHigh
+	}
+
+	l.mu.Lock()
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		l.mu.Unlock()
+		return true
+	}
+
+	if l.queued >= l.cfg.maxQueue && !l.evictLower(p) {
+		l.mu.Unlock()
+		return false
+	}
+
+	wt := &waiter{ready: make(chan bool, 1), priority: p}
+	elem := l.queues[p].PushBack(wt)
+	l.queued++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.cfg.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case ok := <-wt.ready:
+		return ok
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The waiter may have been granted or evicted while we were acquiring the lock.
+	select {
+	case ok := <-wt.ready:
+		return ok
+	default:
+	}
+
+	l.queues[p].Remove(elem)
+	l.queued--
+
+	return false
+}
+
+// evictLower drops the newest waiter with a lower priority than p to make room
+// in the queue. Must be called with l.mu held.
+func (l *Limiter) evictLower(p Priority) bool {
+	for i := PriorityLow; i < p; i++ {
+		if back := l.queues[i].Back(); back != nil {
+			l.queues[i].Remove(back)
+			l.queued--
+			back.Value.(*waiter).ready <- false
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Limiter) release(rtt time.Duration, status int) {
+	l.served.Add(1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	l.adjust(rtt, status)
+
+	for l.inflight < int(l.limit) && l.queued > 0 {
+		for p := PriorityHigh; p >= PriorityLow; p-- {
+			if front := l.queues[p].Front(); front != nil {
+				l.queues[p].Remove(front)
+				l.queued--
+				l.inflight++
+				front.Value.(*waiter).ready <- true
+				break
+			}
+		}
+	}
+}
+
+// adjust updates the concurrency limit from a completed request sample. Must be
+// called with l.mu held.
+func (l *Limiter) adjust(rtt time.Duration, status int) {
+	switch l.cfg.mode {
+	case LimiterAIMD:
+		if rtt > l.cfg.latencyThreshold || status >= http.StatusInternalServerError {
+			l.limit *= l.cfg.backoff
+		} else if l.inflight+1 >= int(l.limit) {
+			l.limit += 1 / l.limit
+		}
+	case LimiterGradient:
+		now := time.Now()
+		if l.minRTT == 0 || rtt < l.minRTT || now.After(l.rttReset) {
+			l.minRTT = rtt
+			l.rttReset = now.Add(time.Minute)
+		}
+		if rtt <= 0 {
+			return
+		}
+		gradient := math.Max(0.5, math.Min(1, float64(l.minRTT)/float64(rtt)))
+		next := l.limit*gradient + math.Sqrt(l.limit)
+		l.limit = 0.8*l.limit + 0.2*next
+	default:
+		return
+	}
+
+	l.limit = math.Max(float64(l.cfg.minLimit), math.Min(float64(l.cfg.maxLimit), l.limit))
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterRejectsWhenFull(t *testing.T) {
+	lim := NewLimiter(
+		WithLimiterLimit(1, 1, 1),
+		WithLimiterQueue(0, 10*time.Millisecond),
+		WithLimiterRetryAfter(2*time.Second),
+	)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := lim.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want %q", got, "2")
+	}
+
+	close(release)
+	wg.Wait()
+
+	stats := lim.Stats()
+	if stats.InFlight != 0 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiterPrefersHigherPriority(t *testing.T) {
+	lim := NewLimiter(
+		WithLimiterLimit(1, 1, 1),
+		WithLimiterQueue(1, time.Second),
+	)
+
+	if !lim.acquire(t.Context(), PriorityNormal) {
+		t.Fatal("first acquire failed")
+	}
+
+	low := make(chan bool, 1)
+	go func() { low <- lim.acquire(t.Context(), PriorityLow) }()
+
+	for lim.Stats().QueueDepth != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	high := make(chan bool, 1)
+	go func() { high <- lim.acquire(t.Context(), PriorityHigh) }()
+
+	if <-low {
+		t.Error("low priority waiter should have been evicted")
+	}
+
+	lim.release(time.Millisecond, http.StatusOK)
+
+	if !<-high {
+		t.Error("high priority waiter should have been admitted")
+	}
+}
@@ -51,7 +51,7 @@ func MiddlewareLogging(log *slog.Logger) func(http.Handler) http.Handler {
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
 			requestID := middleware.GetReqID(r.Context())
-			if requestID != "" {
+			if requestID == "" {
 				requestID = uuid.New().String()
 			}
 
@@ -68,7 +68,7 @@ func MiddlewareLogging(log *slog.Logger) func(http.Handler) http.Handler {
 			}
 			reqLog = reqLog.With("scheme", scheme)
 
-			ctx := context.WithValue(r.Context(), requestLoggerKey{}, requestID)
+			ctx := context.WithValue(r.Context(), requestLoggerKey{}, reqLog)
 			r = r.WithContext(ctx)
 
 			defer func() {
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestMiddlewareLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{name: "generated", requestID: ""},
+		{name: "from request id middleware", requestID: "req-123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+			h := MiddlewareLogging(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				LoggerFromContext(r.Context()).Info("handled")
+			}))
+
+			req := httptest.NewRequest("GET", "/orders", nil)
+			if tt.requestID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, tt.requestID))
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			var ids []string
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var rec map[string]any
+				if err := dec.Decode(&rec); err != nil {
+					t.Fatal(err)
+				}
+				id, _ := rec["request_id"].(string)
+				ids = append(ids, id)
+			}
+
+			// Both the handler's record and "request completed" carry the ID.
+			if len(ids) != 2 || ids[0] == "" || ids[0] != ids[1] {
+				t.Fatalf("request_id = %q, want the same non-empty ID twice", ids)
+			}
+			if tt.requestID != "" && ids[0] != tt.requestID {
+				t.Errorf("request_id = %q, want %q", ids[0], tt.requestID)
+			}
+		})
+	}
+}
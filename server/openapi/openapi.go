@@ -0,0 +1,179 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/derekmwright/web/server"
+)
+
+// Op describes a route for the generated document. Request and the values of
+// Responses are Go values (typically zero values such as CreateOrder{}) whose
+// types are reflected into JSON schemas; a nil response value means no body.
+type Op struct {
+	ID          string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	Query       []Parameter
+	Request     any
+	Responses   map[int]any
+}
+
+type annotated struct {
+	http.Handler
+	op Op
+}
+
+// Annotate attaches operation metadata to h. Register the result with
+// Router.Method or Router.Handle so the metadata survives chi's wrapping:
+//
+//	r.Method(http.MethodPost, "/orders", openapi.Annotate(createOrder, openapi.Op{...}))
+func Annotate(h http.Handler, op Op) http.Handler {
+	return &annotated{Handler: h, op: op}
+}
+
+type Spec struct {
+	cfg config
+}
+
+func New(opts ...Option) *Spec {
+	cfg := config{
+		path: "/openapi.json",
+		info: Info{Title: "API", Version: "0.0.0"},
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return &Spec{cfg: cfg}
+}
+
+// Register serves the document generated from r at the configured path. The
+// document is generated per request so routes added after Register are
+// included.
+func (s *Spec) Register(r chi.Router) {
+	r.Get(s.cfg.path, func(w http.ResponseWriter, req *http.Request) {
+		doc, err := s.Generate(r)
+		if err != nil {
+			server.LoggerFromContext(req.Context()).Error("unable to generate openapi document", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+	})
+}
+
+func (s *Spec) Generate(r chi.Routes) (*Document, error) {
+	routes, err := server.Routes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		OpenAPI: "3.1.0",
+		Info:    s.cfg.info,
+		Servers: s.cfg.servers,
+		Paths:   make(map[string]map[string]*Operation),
+	}
+	schemas := newSchemaRegistry()
+
+	for _, rt := range routes {
+		a, ok := rt.Handler.(*annotated)
+		if !ok && !s.cfg.unannotated {
+			continue
+		}
+		if rt.Pattern == s.cfg.path {
+			continue
+		}
+
+		path, params := convertPattern(rt.Pattern)
+		if path == "" {
+			continue
+		}
+
+		op := &Operation{Responses: make(map[string]*Response)}
+		for _, p := range params {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     p,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+
+		if ok {
+			op.OperationID = a.op.ID
+			op.Summary = a.op.Summary
+			op.Description = a.op.Description
+			op.Tags = a.op.Tags
+			op.Deprecated = a.op.Deprecated
+			op.Parameters = append(op.Parameters, a.op.Query...)
+
+			if a.op.Request != nil {
+				op.RequestBody = &RequestBody{
+					Required: true,
+					Content:  jsonContent(schemas.schemaFor(a.op.Request)),
+				}
+			}
+
+			for status, body := range a.op.Responses {
+				resp := &Response{Description: http.StatusText(status)}
+				if body != nil {
+					resp.Content = jsonContent(schemas.schemaFor(body))
+				}
+				op.Responses[strconv.Itoa(status)] = resp
+			}
+		}
+
+		if len(op.Responses) == 0 {
+			op.Responses["default"] = &Response{Description: "Default response"}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Operation)
+		}
+		doc.Paths[path][strings.ToLower(rt.Method)] = op
+	}
+
+	if len(schemas.defs) > 0 {
+		doc.Components = &Components{Schemas: schemas.defs}
+	}
+
+	return doc, nil
+}
+
+var paramPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// convertPattern turns a chi pattern into an OpenAPI path template, dropping
+// regexp constraints. Catch-all patterns cannot be expressed and yield "".
+func convertPattern(pattern string) (string, []string) {
+	if strings.Contains(pattern, "*") {
+		return "", nil
+	}
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	var params []string
+	path := paramPattern.ReplaceAllStringFunc(pattern, func(m string) string {
+		name := paramPattern.FindStringSubmatch(m)[1]
+		params = append(params, name)
+		return "{" + name + "}"
+	})
+
+	return path, params
+}
+
+func jsonContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
@@ -0,0 +1,123 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type createOrder struct {
+	SKU      string `json:"sku" doc:"Stock keeping unit"`
+	Quantity int    `json:"quantity"`
+	Note     string `json:"note,omitempty"`
+}
+
+type order struct {
+	ID        int64     `json:"id"`
+	Lines     []line    `json:"lines"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type line struct {
+	SKU string `json:"sku"`
+}
+
+// Location shares its name with time.Location.
+type Location struct {
+	Zone  time.Location `json:"zone"`
+	Count uint32        `json:"count"`
+}
+
+func TestGenerate(t *testing.T) {
+	r := chi.NewRouter()
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	r.Route("/orders", func(r chi.Router) {
+		r.Method(http.MethodPost, "/", Annotate(noop, Op{
+			ID:        "createOrder",
+			Request:   createOrder{},
+			Responses: map[int]any{http.StatusCreated: order{}, http.StatusConflict: nil},
+		}))
+		r.Method(http.MethodGet, "/{id:[0-9]+}", Annotate(noop, Op{
+			ID:        "getOrder",
+			Responses: map[int]any{http.StatusOK: &order{}},
+		}))
+	})
+	r.Get("/internal", noop)
+
+	doc, err := New(WithInfo("Orders", "1.0.0")).Generate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := doc.Paths["/internal"]; ok {
+		t.Error("unannotated route should not be documented")
+	}
+
+	create := doc.Paths["/orders"]["post"]
+	if create == nil || create.OperationID != "createOrder" {
+		t.Fatalf("createOrder missing: %+v", doc.Paths)
+	}
+	if create.Responses["409"].Content != nil {
+		t.Error("nil response should have no content")
+	}
+
+	get := doc.Paths["/orders/{id}"]["get"]
+	if get == nil || len(get.Parameters) != 1 || get.Parameters[0].Name != "id" {
+		t.Fatalf("getOrder path parameter missing: %+v", get)
+	}
+
+	req := doc.Components.Schemas["createOrder"]
+	if req == nil || len(req.Required) != 2 || req.Properties["sku"].Description != "Stock keeping unit" {
+		t.Errorf("unexpected createOrder schema: %+v", req)
+	}
+	if doc.Components.Schemas["order"].Properties["created_at"].Format != "date-time" {
+		t.Error("time.Time should be a date-time string")
+	}
+	if doc.Components.Schemas["line"] == nil {
+		t.Error("nested struct schema not registered")
+	}
+}
+
+func TestGenerateWithoutSchemas(t *testing.T) {
+	r := chi.NewRouter()
+	r.Method(http.MethodGet, "/health", Annotate(http.NotFoundHandler(), Op{ID: "health"}))
+
+	doc, err := New().Generate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), `"components"`) {
+		t.Errorf("empty components should be omitted: %s", out)
+	}
+}
+
+func TestSchemaTypes(t *testing.T) {
+	schemas := newSchemaRegistry()
+	schemas.schemaFor(Location{})
+
+	loc := schemas.defs["Location"]
+	if loc == nil {
+		t.Fatalf("Location schema missing: %v", schemas.defs)
+	}
+	if ref := loc.Properties["zone"].Ref; ref != "#/components/schemas/time.Location" {
+		t.Errorf("time.Location ref = %q, want package-qualified name", ref)
+	}
+	if schemas.defs["time.Location"] == nil {
+		t.Error("time.Location schema not registered")
+	}
+
+	count := loc.Properties["count"]
+	if count.Format != "int64" || count.Minimum == nil || *count.Minimum != 0 {
+		t.Errorf("uint32 schema = %+v, want int64 with minimum 0", count)
+	}
+}
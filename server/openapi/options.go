@@ -0,0 +1,35 @@
+package openapi
+
+type Option func(*config)
+
+type config struct {
+	path        string
+	info        Info
+	servers     []Server
+	unannotated bool
+}
+
+func WithPath(path string) Option {
+	return func(c *config) { c.path = path }
+}
+
+func WithInfo(title, version string) Option {
+	return func(c *config) {
+		c.info.Title = title
+		c.info.Version = version
+	}
+}
+
+func WithDescription(desc string) Option {
+	return func(c *config) { c.info.Description = desc }
+}
+
+func WithServer(url, description string) Option {
+	return func(c *config) { c.servers = append(c.servers, Server{URL: url, Description: description}) }
+}
+
+// WithUnannotated includes routes that were registered without operation
+// metadata, documented only by their method, path and path parameters.
+func WithUnannotated(include bool) Option {
+	return func(c *config) { c.unannotated = include }
+}
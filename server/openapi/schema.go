@@ -0,0 +1,137 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type schemaRegistry struct {
+	defs  map[string]*Schema
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		defs:  make(map[string]*Schema),
+		names: make(map[reflect.Type]string),
+		types: make(map[string]reflect.Type),
+	}
+}
+
+func (s *schemaRegistry) schemaFor(v any) *Schema {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemaRegistry) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32", Minimum: new(float64)}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// There is no unsigned format; int64 with a minimum is the closest.
+		return &Schema{Type: "integer", Format: "int64", Minimum: new(float64)}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name, ok := s.names[t]
+		if !ok {
+			name = s.register(t)
+			// Reserve the name first so recursive types terminate.
+			s.defs[name] = &Schema{}
+			*s.defs[name] = *s.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// register names the component for t after its Go type, qualified by package
+// path when a type from another package already took the short name.
+func (s *schemaRegistry) register(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := s.types[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+
+	s.names[t] = name
+	s.types[name] = t
+	return name
+}
+
+func (s *schemaRegistry) structSchema(t reflect.Type) *Schema {
+	sc := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			embedded := s.schema(f.Type)
+			if embedded.Ref != "" {
+				embedded = s.defs[strings.TrimPrefix(embedded.Ref, "#/components/schemas/")]
+			}
+			for k, v := range embedded.Properties {
+				sc.Properties[k] = v
+			}
+			sc.Required = append(sc.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := s.schema(f.Type)
+		if desc := f.Tag.Get("doc"); desc != "" {
+			described := *fs
+			described.Description = desc
+			fs = &described
+		}
+		sc.Properties[name] = fs
+
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") && f.Type.Kind() != reflect.Pointer {
+			sc.Required = append(sc.Required, name)
+		}
+	}
+
+	return sc
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"flag"
 	"log/slog"
 	"net/http"
 	"time"
@@ -37,3 +38,15 @@ func WithIdleTimeout(d time.Duration) Option {
 func WithMiddleware(mw func(http.Handler) http.Handler) Option {
 	return func(server *Server) { server.Router.Use(mw) }
 }
+
+// WithRoutesFlag registers a -routes flag on fs (flag.CommandLine when nil).
+// When it is set, Start prints the route table to stdout and returns instead
+// of serving. Parse the flags before calling Start.
+func WithRoutesFlag(fs *flag.FlagSet) Option {
+	return func(s *Server) {
+		if fs == nil {
+			fs = flag.CommandLine
+		}
+		s.printRoutes = fs.Bool("routes", false, "print the route table and exit")
+	}
+}
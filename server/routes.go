@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type RouteInfo struct {
+	Method     string
+	Pattern    string
+	Handler    http.Handler
+	Middleware []string
+}
+
+// Routes lists every route registered on r, including routes on mounted
+// subrouters, sorted by pattern and method.
+func Routes(r chi.Routes) ([]RouteInfo, error) {
+	var routes []RouteInfo
+
+	err := chi.Walk(r, func(method, route string, handler http.Handler, mws ...func(http.Handler) http.Handler) error {
+		info := RouteInfo{Method: method, Pattern: route}
+
+		for _, mw := range mws {
+			info.Middleware = append(info.Middleware, funcName(mw))
+		}
+
+		// Inline middleware added with Router.With lives on the chain handler.
+		for {
+			ch, ok := handler.(*chi.ChainHandler)
+			if !ok {
+				break
+			}
+			for _, mw := range ch.Middlewares {
+				info.Middleware = append(info.Middleware, funcName(mw))
+			}
+			handler = ch.Endpoint
+		}
+		info.Handler = handler
+
+		routes = append(routes, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes, nil
+}
+
+func WriteRoutes(w io.Writer, r chi.Routes) error {
+	routes, err := Routes(r)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATTERN\tMIDDLEWARE")
+	for _, rt := range routes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", rt.Method, rt.Pattern, strings.Join(rt.Middleware, ", "))
+	}
+
+	return tw.Flush()
+}
+
+// PrintRoutes writes the route table for debugging, e.g. when the binary is
+// started with a "routes" argument instead of serving.
+func (s *Server) PrintRoutes(w io.Writer) error {
+	return WriteRoutes(w, s.Router)
+}
+
+func funcName(fn any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, ".func1")
+}
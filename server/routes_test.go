@@ -0,0 +1,32 @@
+package server
+
+import (
+	"bytes"
+	"flag"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRoutesFlag(t *testing.T) {
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	s := New(WithRoutesFlag(fs), WithLogger(slog.New(slog.DiscardHandler)))
+	s.Router.Post("/orders", func(http.ResponseWriter, *http.Request) {})
+
+	var out bytes.Buffer
+	s.routesOut = &out
+
+	if err := fs.Parse([]string{"-routes"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"METHOD", "GET     /healthz", "POST    /orders"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("route table missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -27,6 +28,9 @@ type Server struct {
 	writeTimeout    time.Duration
 	idleTimeout     time.Duration
 	shutdownTimeout time.Duration
+
+	printRoutes *bool
+	routesOut   io.Writer
 }
 
 func New(opts ...Option) *Server {
@@ -37,6 +41,7 @@ func New(opts ...Option) *Server {
 		readTimeout:     5 * time.Second,
 		writeTimeout:    10 * time.Second,
 		idleTimeout:     30 * time.Second,
+		routesOut:       os.Stdout,
 	}
 
 	for _, opt := range opts {
@@ -79,6 +84,10 @@ func isTLSEnabled() bool {
 }
 
 func (s *Server) Start() error {
+	if s.printRoutes != nil && *s.printRoutes {
+		return s.PrintRoutes(s.routesOut)
+	}
+
 	s.Log.Info("starting server", "addr", s.srv.Addr)
 
 	go func() {
@@ -0,0 +1,218 @@
+package servertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/server"
+)
+
+// Client is an HTTP client bound to a Server. Each client has its own cookie
+// jar and does not follow redirects, so redirects can be asserted directly.
+type Client struct {
+	srv    *Server
+	http   *http.Client
+	userID string
+	header http.Header
+}
+
+func (s *Server) Client() *Client {
+	jar, _ := cookiejar.New(nil)
+
+	hc := s.ts.Client()
+	hc.Jar = jar
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Client{srv: s, http: hc, header: make(http.Header)}
+}
+
+// As returns a copy of the client that is authenticated as user.
+func (c *Client) As(user auth0.SessionUser) *Client {
+	clone := *c
+	clone.header = c.header.Clone()
+	clone.userID = c.srv.registerUser(user)
+	return &clone
+}
+
+// WithHeader returns a copy of the client that sends the header on every request.
+func (c *Client) WithHeader(key, value string) *Client {
+	clone := *c
+	clone.header = c.header.Clone()
+	clone.header.Set(key, value)
+	return &clone
+}
+
+func (c *Client) Get(path string) *Request    { return c.NewRequest(http.MethodGet, path) }
+func (c *Client) Post(path string) *Request   { return c.NewRequest(http.MethodPost, path) }
+func (c *Client) Put(path string) *Request    { return c.NewRequest(http.MethodPut, path) }
+func (c *Client) Patch(path string) *Request  { return c.NewRequest(http.MethodPatch, path) }
+func (c *Client) Delete(path string) *Request { return c.NewRequest(http.MethodDelete, path) }
+
+func (c *Client) NewRequest(method, path string) *Request {
+	return &Request{client: c, method: method, path: path, header: c.header.Clone()}
+}
+
+type Request struct {
+	client *Client
+	method string
+	path   string
+	header http.Header
+	body   io.Reader
+}
+
+func (r *Request) Header(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+func (r *Request) Body(body io.Reader) *Request {
+	r.body = body
+	return r
+}
+
+func (r *Request) JSON(v any) *Request {
+	t := r.client.srv.t
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("servertest: unable to encode JSON body: %v", err)
+	}
+
+	r.header.Set("Content-Type", "application/json")
+	r.body = bytes.NewReader(b)
+	return r
+}
+
+func (r *Request) Do() *Response {
+	t := r.client.srv.t
+	t.Helper()
+
+	req, err := http.NewRequest(r.method, r.client.srv.URL+r.path, r.body)
+	if err != nil {
+		t.Fatalf("servertest: unable to build request: %v", err)
+	}
+	req.Header = r.header
+	if r.client.userID != "" {
+		req.Header.Set(userHeader, r.client.userID)
+	}
+
+	resp, err := r.client.http.Do(req)
+	if err != nil {
+		t.Fatalf("servertest: %s %s failed: %v", r.method, r.path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("servertest: unable to read response body: %v", err)
+	}
+
+	return &Response{Response: resp, Body: body, t: t}
+}
+
+type Response struct {
+	*http.Response
+	Body []byte
+
+	t testing.TB
+}
+
+func (r *Response) AssertStatus(want int) *Response {
+	r.t.Helper()
+	if r.StatusCode != want {
+		r.t.Errorf("status = %d, want %d; body: %s", r.StatusCode, want, r.Body)
+	}
+	return r
+}
+
+func (r *Response) AssertHeader(key, want string) *Response {
+	r.t.Helper()
+	if got := r.Header.Get(key); got != want {
+		r.t.Errorf("header %s = %q, want %q", key, got, want)
+	}
+	return r
+}
+
+func (r *Response) AssertRedirect(wantPrefix string) *Response {
+	r.t.Helper()
+	if r.StatusCode < 300 || r.StatusCode >= 400 {
+		r.t.Errorf("status = %d, want a redirect", r.StatusCode)
+	}
+	if got := r.Header.Get("Location"); !strings.HasPrefix(got, wantPrefix) {
+		r.t.Errorf("Location = %q, want prefix %q", got, wantPrefix)
+	}
+	return r
+}
+
+func (r *Response) AssertBodyContains(want string) *Response {
+	r.t.Helper()
+	if !strings.Contains(string(r.Body), want) {
+		r.t.Errorf("body does not contain %q; body: %s", want, r.Body)
+	}
+	return r
+}
+
+// AssertJSON compares the response body with want after decoding both into
+// generic JSON values, so field order and formatting do not matter.
+func (r *Response) AssertJSON(want any) *Response {
+	r.t.Helper()
+
+	b, err := json.Marshal(want)
+	if err != nil {
+		r.t.Fatalf("servertest: unable to encode expected JSON: %v", err)
+	}
+
+	var wantV, gotV any
+	json.Unmarshal(b, &wantV)
+	if err = json.Unmarshal(r.Body, &gotV); err != nil {
+		r.t.Errorf("body is not valid JSON: %v; body: %s", err, r.Body)
+		return r
+	}
+
+	if !reflect.DeepEqual(gotV, wantV) {
+		r.t.Errorf("JSON body = %s, want %s", r.Body, b)
+	}
+	return r
+}
+
+func (r *Response) DecodeJSON(v any) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("servertest: unable to decode JSON body: %v; body: %s", err, r.Body)
+	}
+	return r
+}
+
+// AssertProblem checks for an RFC 9457 problem response with the given status
+// and, when non-empty, title.
+func (r *Response) AssertProblem(status int, title string) *Response {
+	r.t.Helper()
+
+	r.AssertStatus(status)
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, server.ProblemContentType) {
+		r.t.Errorf("Content-Type = %q, want %q", ct, server.ProblemContentType)
+	}
+
+	var p server.Problem
+	if err := json.Unmarshal(r.Body, &p); err != nil {
+		r.t.Errorf("body is not a problem document: %v; body: %s", err, r.Body)
+		return r
+	}
+	if p.Status != status {
+		r.t.Errorf("problem status = %d, want %d", p.Status, status)
+	}
+	if title != "" && p.Title != title {
+		r.t.Errorf("problem title = %q, want %q", p.Title, title)
+	}
+	return r
+}
@@ -0,0 +1,118 @@
+package servertest
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type LogEntry struct {
+	Level   slog.Level
+	Message string
+	Attrs   map[string]any
+}
+
+// LogRecorder is a slog.Handler that keeps every record in memory. Group names
+// are flattened into dotted attribute keys.
+type LogRecorder struct {
+	state *logState
+	attrs []slog.Attr
+	group string
+}
+
+type logState struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+func NewLogRecorder() *LogRecorder {
+	return &LogRecorder{state: &logState{}}
+}
+
+func (l *LogRecorder) Logger() *slog.Logger {
+	return slog.New(l)
+}
+
+func (l *LogRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (l *LogRecorder) Handle(_ context.Context, rec slog.Record) error {
+	entry := LogEntry{
+		Level:   rec.Level,
+		Message: rec.Message,
+		Attrs:   make(map[string]any),
+	}
+
+	for _, a := range l.attrs {
+		addAttr(entry.Attrs, "", a)
+	}
+	rec.Attrs(func(a slog.Attr) bool {
+		addAttr(entry.Attrs, l.group, a)
+		return true
+	})
+
+	l.state.mu.Lock()
+	l.state.entries = append(l.state.entries, entry)
+	l.state.mu.Unlock()
+
+	return nil
+}
+
+func (l *LogRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *l
+	clone.attrs = append([]slog.Attr{}, l.attrs...)
+	for _, a := range attrs {
+		if l.group != "" {
+			a.Key = l.group + a.Key
+		}
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+func (l *LogRecorder) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return l
+	}
+	clone := *l
+	clone.group = l.group + name + "."
+	return &clone
+}
+
+func (l *LogRecorder) Entries() []LogEntry {
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+	return append([]LogEntry(nil), l.state.entries...)
+}
+
+// Find returns all entries whose message contains msg.
+func (l *LogRecorder) Find(msg string) []LogEntry {
+	var found []LogEntry
+	for _, e := range l.Entries() {
+		if strings.Contains(e.Message, msg) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (l *LogRecorder) Contains(msg string) bool {
+	return len(l.Find(msg)) > 0
+}
+
+func (l *LogRecorder) Reset() {
+	l.state.mu.Lock()
+	l.state.entries = nil
+	l.state.mu.Unlock()
+}
+
+func addAttr(dst map[string]any, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			addAttr(dst, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	dst[prefix+a.Key] = a.Value.Any()
+}
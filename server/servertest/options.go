@@ -0,0 +1,30 @@
+package servertest
+
+import (
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/server"
+)
+
+type Option func(*config)
+
+type config struct {
+	serverOpts []server.Option
+	user       *auth0.SessionUser
+	tls        bool
+}
+
+// WithServerOptions passes options through to server.New, so tests build the
+// server exactly as production code does.
+func WithServerOptions(opts ...server.Option) Option {
+	return func(c *config) { c.serverOpts = append(c.serverOpts, opts...) }
+}
+
+// WithUser authenticates every request as user unless a client overrides it
+// with Client.As.
+func WithUser(user auth0.SessionUser) Option {
+	return func(c *config) { c.user = &user }
+}
+
+func WithTLS() Option {
+	return func(c *config) { c.tls = true }
+}
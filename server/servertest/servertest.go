@@ -0,0 +1,97 @@
+package servertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/internal/testauth"
+	"github.com/derekmwright/web/server"
+)
+
+const userHeader = "Servertest-User"
+
+// Server is a server.Server listening on an ephemeral port for the duration of
+// a test. It is closed automatically through t.Cleanup.
+type Server struct {
+	*server.Server
+
+	URL  string
+	Logs *LogRecorder
+
+	t  testing.TB
+	ts *httptest.Server
+
+	mu          sync.Mutex
+	users       map[string]auth0.SessionUser
+	defaultUser *auth0.SessionUser
+}
+
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s := &Server{
+		t:           t,
+		Logs:        NewLogRecorder(),
+		users:       make(map[string]auth0.SessionUser),
+		defaultUser: cfg.user,
+	}
+
+	serverOpts := append(cfg.serverOpts,
+		server.WithLogger(s.Logs.Logger()),
+		server.WithMiddleware(s.injectUser),
+	)
+	s.Server = server.New(serverOpts...)
+
+	if cfg.tls {
+		s.ts = httptest.NewTLSServer(s.Router)
+	} else {
+		s.ts = httptest.NewServer(s.Router)
+	}
+	s.URL = s.ts.URL
+
+	t.Cleanup(s.ts.Close)
+
+	return s
+}
+
+// ServeHTTP dispatches directly to the router without going over the network.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Router.ServeHTTP(w, r)
+}
+
+func (s *Server) registerUser(user auth0.SessionUser) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := strconv.Itoa(len(s.users) + 1)
+	s.users[id] = user
+	return id
+}
+
+func (s *Server) injectUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		user, ok := s.users[r.Header.Get(userHeader)]
+		if !ok && s.defaultUser != nil {
+			user, ok = *s.defaultUser, true
+		}
+		s.mu.Unlock()
+
+		r.Header.Del(userHeader)
+
+		if ok {
+			r = r.WithContext(testauth.Trust(auth0.ContextWithUser(r.Context(), user)))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
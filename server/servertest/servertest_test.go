@@ -0,0 +1,45 @@
+package servertest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/server"
+)
+
+func TestServer(t *testing.T) {
+	ts := New(t)
+
+	ts.Router.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		server.LoggerFromContext(r.Context()).Info("rejecting request")
+		server.WriteProblem(w, server.NewProblem(http.StatusUnauthorized, "not logged in"))
+	})
+
+	c := ts.Client()
+
+	c.Get("/healthz").Do().AssertStatus(http.StatusOK).AssertBodyContains("ok")
+	c.Get("/fail").Do().AssertProblem(http.StatusUnauthorized, "Unauthorized")
+
+	entries := ts.Logs.Find("rejecting request")
+	if len(entries) != 1 {
+		t.Fatalf("captured %d request-scoped records, want 1", len(entries))
+	}
+	if entries[0].Attrs["path"] != "/fail" {
+		t.Errorf("request-scoped record missing path: %v", entries[0].Attrs)
+	}
+}
+
+func TestClientAs(t *testing.T) {
+	ts := New(t)
+
+	ts.Router.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"sub":"` + auth0.CurrentUser(r).Sub + `"}`))
+	})
+
+	ts.Client().As(auth0.SessionUser{Sub: "auth0|123"}).
+		Get("/me").Do().
+		AssertStatus(http.StatusOK).
+		AssertJSON(map[string]string{"sub": "auth0|123"})
+}
@@ -0,0 +1,12 @@
+package session
+
+import "errors"
+
+var (
+	ErrNilStore       = errors.New("store cannot be nil")
+	ErrNilLogger      = errors.New("logger cannot be nil")
+	ErrNilDatabase    = errors.New("database cannot be nil")
+	ErrBucketRequired = errors.New("bucket name required")
+	ErrInvalidKey     = errors.New("cookie key must be 32 bytes")
+	ErrCookieTooLarge = errors.New("session too large for a cookie")
+)
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// KVStore stores sessions in a JetStream key/value bucket. The bucket TTL
+// should be at least the session lifetime; expiry is also checked on read.
+// Keys are SHA-256 digests of the token, so any cookie value is a valid key
+// and tokens are not exposed to anyone listing the bucket.
+type KVStore struct {
+	kv nats.KeyValue
+}
+
+func NewKVStore(js nats.JetStreamContext, bucket string, ttl time.Duration) (*KVStore, error) {
+	if bucket == "" {
+		return nil, ErrBucketRequired
+	}
+
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket: bucket,
+			TTL:    ttl,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVStore{kv: kv}, nil
+}
+
+func (s *KVStore) Find(ctx context.Context, token string) ([]byte, bool, error) {
+	entry, err := s.kv.Get(kvKey(token))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Values are prefixed with the expiry in Unix nanoseconds.
+	v := entry.Value()
+	if len(v) < 8 || time.Now().UnixNano() > int64(binary.BigEndian.Uint64(v)) {
+		return nil, false, nil
+	}
+
+	return v[8:], true, nil
+}
+
+func (s *KVStore) Commit(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	v := make([]byte, 8, 8+len(b))
+	binary.BigEndian.PutUint64(v, uint64(expiry.UnixNano()))
+
+	_, err := s.kv.Put(kvKey(token), append(v, b...))
+	return err
+}
+
+func (s *KVStore) Delete(ctx context.Context, token string) error {
+	err := s.kv.Delete(kvKey(token))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+func kvKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
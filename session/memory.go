@@ -0,0 +1,51 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	b      []byte
+	expiry time.Time
+}
+
+// MemoryStore keeps sessions in process. It is suitable for tests and single
+// replica deployments only.
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]memoryItem)}
+}
+
+func (m *MemoryStore) Find(ctx context.Context, token string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[token]
+	if !ok || time.Now().After(item.expiry) {
+		delete(m.items, token)
+		return nil, false, nil
+	}
+	return item.b, true, nil
+}
+
+func (m *MemoryStore) Commit(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[token] = memoryItem{b: b, expiry: expiry}
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.items, token)
+	return nil
+}
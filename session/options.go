@@ -0,0 +1,58 @@
+package session
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type Option func(*config)
+
+type config struct {
+	lifetime    time.Duration
+	idleTimeout time.Duration
+	cookie      http.Cookie
+	persist     bool
+	log         *slog.Logger
+}
+
+// WithLifetime sets the absolute session lifetime. The deadline restarts when
+// the token is renewed, e.g. on login.
+func WithLifetime(d time.Duration) Option {
+	return func(c *config) { c.lifetime = d }
+}
+
+// WithIdleTimeout expires sessions that see no requests for d. Every request
+// then extends the session, which costs a store write.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) { c.idleTimeout = d }
+}
+
+func WithCookieName(name string) Option {
+	return func(c *config) { c.cookie.Name = name }
+}
+
+func WithCookieDomain(domain string) Option {
+	return func(c *config) { c.cookie.Domain = domain }
+}
+
+func WithCookiePath(path string) Option {
+	return func(c *config) { c.cookie.Path = path }
+}
+
+func WithSecure(secure bool) Option {
+	return func(c *config) { c.cookie.Secure = secure }
+}
+
+func WithSameSite(s http.SameSite) Option {
+	return func(c *config) { c.cookie.SameSite = s }
+}
+
+// WithPersist controls whether the cookie outlives the browser session.
+func WithPersist(persist bool) Option {
+	return func(c *config) { c.persist = persist }
+}
+
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/derekmwright/web/database/pg"
+)
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const (
+	MigrationsDir   = "migrations"
+	MigrationsTable = "session_goose_db_version"
+)
+
+// PGStore stores sessions in the sessions table, which has the same layout as
+// scs/postgresstore. Apply the schema with
+// pg.Migrate using Migrations, MigrationsDir and MigrationsTable.
+type PGStore struct {
+	db *pg.Database
+}
+
+func NewPGStore(db *pg.Database) (*PGStore, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	return &PGStore{db: db}, nil
+}
+
+func (s *PGStore) Find(ctx context.Context, token string) ([]byte, bool, error) {
+	var b []byte
+	err := s.db.Pool.QueryRow(ctx,
+		`SELECT data FROM sessions WHERE token = $1 AND expiry > now()`,
+		token,
+	).Scan(&b)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (s *PGStore) Commit(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	_, err := s.db.Pool.Exec(ctx, `
+		INSERT INTO sessions (token, data, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry`,
+		token, b, expiry,
+	)
+	return err
+}
+
+func (s *PGStore) Delete(ctx context.Context, token string) error {
+	_, err := s.db.Pool.Exec(ctx, `DELETE FROM sessions WHERE token = $1`, token)
+	return err
+}
+
+// PurgeExpired deletes expired sessions and returns how many were removed.
+func (s *PGStore) PurgeExpired(ctx context.Context) (int64, error) {
+	tag, err := s.db.Pool.Exec(ctx, `DELETE FROM sessions WHERE expiry <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// StartCleanup calls PurgeExpired every interval until ctx is cancelled,
+// logging failures to log, or slog.Default() if log is nil.
+func (s *PGStore) StartCleanup(ctx context.Context, interval time.Duration, log *slog.Logger) {
+	if log == nil {
+		log = slog.Default()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.PurgeExpired(ctx); err != nil && ctx.Err() == nil {
+					log.Error("unable to purge expired sessions", "error", err)
+				}
+			}
+		}
+	}()
+}
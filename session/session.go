@@ -0,0 +1,407 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Store persists encoded sessions by token. The interface matches scs stores,
+// so those can be used as well.
+type Store interface {
+	Find(ctx context.Context, token string) (b []byte, found bool, err error)
+	Commit(ctx context.Context, token string, b []byte, expiry time.Time) error
+	Delete(ctx context.Context, token string) error
+}
+
+// Manager loads sessions for each request and satisfies auth0.SessionManager.
+// Sessions are kept server side in a Store, or entirely in an encrypted cookie
+// when created with NewCookie.
+type Manager struct {
+	store Store
+	aead  cipher.AEAD
+	cfg   config
+}
+
+type status int
+
+const (
+	unmodified status = iota
+	modified
+	destroyed
+)
+
+type sessionData struct {
+	mu       sync.Mutex
+	token    string
+	loaded   bool
+	deadline time.Time
+	values   map[string]any
+	status   status
+}
+
+type record struct {
+	Deadline time.Time
+	Values   map[string]any
+}
+
+type contextKey struct{ m *Manager }
+
+func New(store Store, opts ...Option) (*Manager, error) {
+	if store == nil {
+		return nil, ErrNilStore
+	}
+	return newManager(store, nil, opts)
+}
+
+// NewCookie stores sessions in the cookie itself, encrypted and authenticated
+// with AES-GCM under key, which must be 32 bytes. Sessions cannot be revoked
+// server side and must stay under the 4KB cookie limit.
+func NewCookie(key []byte, opts ...Option) (*Manager, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return newManager(nil, aead, opts)
+}
+
+func newManager(store Store, aead cipher.AEAD, opts []Option) (*Manager, error) {
+	cfg := config{
+		lifetime: 24 * time.Hour,
+		cookie: http.Cookie{
+			Name:     "session",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		persist: true,
+		log:     slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.log == nil {
+		return nil, ErrNilLogger
+	}
+
+	return &Manager{store: store, aead: aead, cfg: cfg}, nil
+}
+
+// LoadAndSave loads the session named by the request cookie into the context
+// and writes it back before the response headers are sent.
+func (m *Manager) LoadAndSave(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Cookie")
+
+		sd, err := m.load(r)
+		if err != nil {
+			m.cfg.log.Error("unable to load session", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{m}, sd))
+
+		sw := &sessionWriter{ResponseWriter: w}
+		sw.commit = func() {
+			if err := m.save(r.Context(), w, sd); err != nil {
+				m.cfg.log.Error("unable to save session", "error", err)
+			}
+		}
+
+		next.ServeHTTP(sw, r)
+		sw.commitOnce()
+	})
+}
+
+func (m *Manager) Get(ctx context.Context, key string) any {
+	sd := m.data(ctx)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return sd.values[key]
+}
+
+// Put sets key to value. A nil value removes the key.
+func (m *Manager) Put(ctx context.Context, key string, value any) {
+	sd := m.data(ctx)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if value == nil {
+		if _, ok := sd.values[key]; !ok {
+			return
+		}
+		delete(sd.values, key)
+	} else {
+		sd.values[key] = value
+	}
+	sd.status = modified
+}
+
+// RenewToken moves the session to a new token and restarts its lifetime. Call
+// it whenever privileges change, e.g. on login, to prevent session fixation.
+func (m *Manager) RenewToken(ctx context.Context) error {
+	sd := m.data(ctx)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if m.store != nil && sd.token != "" {
+		if err := m.store.Delete(ctx, sd.token); err != nil {
+			return err
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	sd.token = token
+	sd.deadline = time.Now().Add(m.cfg.lifetime)
+	sd.status = modified
+
+	return nil
+}
+
+// Destroy deletes the session and expires its cookie. Values put afterwards
+// start a new session.
+func (m *Manager) Destroy(ctx context.Context) error {
+	sd := m.data(ctx)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if m.store != nil && sd.token != "" {
+		if err := m.store.Delete(ctx, sd.token); err != nil {
+			return err
+		}
+	}
+
+	sd.token = ""
+	sd.deadline = time.Now().Add(m.cfg.lifetime)
+	sd.values = make(map[string]any)
+	sd.status = destroyed
+
+	return nil
+}
+
+func (m *Manager) data(ctx context.Context) *sessionData {
+	sd, ok := ctx.Value(contextKey{m}).(*sessionData)
+	if !ok {
+		panic("session: no session data in context; wrap the handler with LoadAndSave")
+	}
+	return sd
+}
+
+func (m *Manager) load(r *http.Request) (*sessionData, error) {
+	sd := &sessionData{
+		deadline: time.Now().Add(m.cfg.lifetime),
+		values:   make(map[string]any),
+	}
+
+	cookie, err := r.Cookie(m.cfg.cookie.Name)
+	if err != nil {
+		return sd, nil
+	}
+
+	var b []byte
+	if m.store != nil {
+		var found bool
+		b, found, err = m.store.Find(r.Context(), cookie.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return sd, nil
+		}
+		sd.token = cookie.Value
+	} else if b, err = m.decrypt(cookie.Value); err != nil {
+		// A cookie that fails to decrypt was tampered with or sealed under an
+		// old key; start over rather than failing the request.
+		return sd, nil
+	}
+
+	var rec record
+	if err = gob.NewDecoder(bytes.NewReader(b)).Decode(&rec); err != nil {
+		// Usually a value type that was renamed or is no longer registered
+		// with gob; the session cannot be recovered, so start over.
+		m.cfg.log.Warn("discarding undecodable session", "error", err)
+		sd.token = ""
+		return sd, nil
+	}
+
+	if time.Now().After(rec.Deadline) {
+		sd.token = ""
+		return sd, nil
+	}
+
+	sd.loaded = true
+	sd.deadline = rec.Deadline
+	if rec.Values != nil {
+		sd.values = rec.Values
+	}
+
+	return sd, nil
+}
+
+func (m *Manager) save(ctx context.Context, w http.ResponseWriter, sd *sessionData) error {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	switch sd.status {
+	case destroyed:
+		if len(sd.values) == 0 {
+			m.writeCookie(w, "", time.Unix(1, 0))
+			return nil
+		}
+	case unmodified:
+		if m.cfg.idleTimeout == 0 || !sd.loaded {
+			return nil
+		}
+	default:
+		if len(sd.values) == 0 && !sd.loaded {
+			return nil
+		}
+	}
+
+	expiry := sd.deadline
+	if idle := time.Now().Add(m.cfg.idleTimeout); m.cfg.idleTimeout > 0 && idle.Before(expiry) {
+		expiry = idle
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record{Deadline: sd.deadline, Values: sd.values}); err != nil {
+		return err
+	}
+
+	var value string
+	if m.store != nil {
+		if sd.token == "" {
+			token, err := newToken()
+			if err != nil {
+				return err
+			}
+			sd.token = token
+		}
+		if err := m.store.Commit(ctx, sd.token, buf.Bytes(), expiry); err != nil {
+			return err
+		}
+		value = sd.token
+	} else {
+		var err error
+		if value, err = m.encrypt(buf.Bytes()); err != nil {
+			return err
+		}
+		if len(value) > 4000 {
+			return ErrCookieTooLarge
+		}
+	}
+
+	m.writeCookie(w, value, expiry)
+	return nil
+}
+
+func (m *Manager) writeCookie(w http.ResponseWriter, value string, expiry time.Time) {
+	cookie := m.cfg.cookie
+	cookie.Value = value
+
+	if value == "" {
+		cookie.Expires = expiry
+		cookie.MaxAge = -1
+	} else if m.cfg.persist {
+		cookie.Expires = expiry.UTC()
+		cookie.MaxAge = int(time.Until(expiry).Seconds() + 1)
+	}
+
+	w.Header().Add("Set-Cookie", cookie.String())
+	w.Header().Add("Cache-Control", `no-cache="Set-Cookie"`)
+}
+
+func (m *Manager) encrypt(b []byte) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize(), m.aead.NonceSize()+len(b)+m.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := m.aead.Seal(nonce, nonce, b, []byte(m.cfg.cookie.Name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (m *Manager) decrypt(value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	n := m.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("session cookie too short")
+	}
+
+	return m.aead.Open(nil, sealed[:n], sealed[n:], []byte(m.cfg.cookie.Name))
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sessionWriter commits the session just before the first byte of the
+// response is written, so the cookie can still be set without buffering.
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (sw *sessionWriter) commitOnce() {
+	if !sw.committed {
+		sw.committed = true
+		sw.commit()
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	sw.commitOnce()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.commitOnce()
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *sessionWriter) Flush() {
+	sw.commitOnce()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
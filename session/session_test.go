@@ -0,0 +1,183 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derekmwright/web/nats"
+)
+
+func TestManager(t *testing.T) {
+	store := NewMemoryStore()
+	storeManager, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieManager, err := NewCookie(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, m := range map[string]*Manager{"store": storeManager, "cookie": cookieManager} {
+		t.Run(name, func(t *testing.T) {
+			var cookie *http.Cookie
+
+			do := func(fn func(ctx context.Context)) *http.Cookie {
+				req := httptest.NewRequest("GET", "/", nil)
+				if cookie != nil {
+					req.AddCookie(cookie)
+				}
+				rr := httptest.NewRecorder()
+				m.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fn(r.Context())
+					w.WriteHeader(http.StatusNoContent)
+				})).ServeHTTP(rr, req)
+
+				for _, c := range rr.Result().Cookies() {
+					return c
+				}
+				return nil
+			}
+
+			if c := do(func(ctx context.Context) { m.Get(ctx, "user") }); c != nil {
+				t.Fatal("empty session should not set a cookie")
+			}
+
+			cookie = do(func(ctx context.Context) { m.Put(ctx, "user", "alice") })
+			if cookie == nil {
+				t.Fatal("expected session cookie")
+			}
+
+			first := cookie.Value
+			cookie = do(func(ctx context.Context) {
+				if got := m.Get(ctx, "user"); got != "alice" {
+					t.Errorf("user = %v, want alice", got)
+				}
+				if err := m.RenewToken(ctx); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if cookie == nil || cookie.Value == first {
+				t.Fatal("renew should issue a new cookie value")
+			}
+
+			if m.store != nil {
+				if _, found, _ := store.Find(t.Context(), first); found {
+					t.Error("old token should be deleted on renew")
+				}
+			}
+
+			expired := do(func(ctx context.Context) {
+				if err := m.Destroy(ctx); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if expired == nil || expired.MaxAge >= 0 {
+				t.Fatalf("destroy should expire the cookie, got %+v", expired)
+			}
+
+			if m.store != nil {
+				do(func(ctx context.Context) {
+					if got := m.Get(ctx, "user"); got != nil {
+						t.Errorf("destroyed session still has user %v", got)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestCookieTampering(t *testing.T) {
+	m, err := NewCookie(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "bm90LWEtcmVhbC1zZXNzaW9uLWNvb2tpZQ"})
+	rr := httptest.NewRecorder()
+
+	m.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := m.Get(r.Context(), "user"); got != nil {
+			t.Errorf("tampered cookie yielded user %v", got)
+		}
+	})).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestUndecodableSession(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.Commit(t.Context(), "stale", []byte("not gob"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "stale"})
+	rr := httptest.NewRecorder()
+
+	m.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r.Context(), "user", "alice")
+	})).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == "stale" {
+		t.Errorf("cookies = %v, want a fresh session", cookies)
+	}
+}
+
+func TestKVStoreInvalidToken(t *testing.T) {
+	nc, shutdown, err := nats.New(nats.WithJetStream(true, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewKVStore(js, "sessions", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cookie values are client controlled and need not be valid KV keys.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "not a key*>"})
+	rr := httptest.NewRecorder()
+
+	m.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r.Context(), "user", "alice")
+	})).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %v, want a fresh session", cookies)
+	}
+	if _, found, err := store.Find(t.Context(), cookies[0].Value); err != nil || !found {
+		t.Errorf("Find = %v, %v, want the new session", found, err)
+	}
+}
@@ -0,0 +1,9 @@
+package users
+
+import "errors"
+
+var (
+	ErrNilDatabase = errors.New("database cannot be nil")
+	ErrNilLogger   = errors.New("logger cannot be nil")
+	ErrNotFound    = errors.New("user not found")
+)
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS auth_users (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    sub            TEXT NOT NULL UNIQUE,
+    email          TEXT NOT NULL DEFAULT '',
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    name           TEXT NOT NULL DEFAULT '',
+    picture        TEXT NOT NULL DEFAULT '',
+    login_count    INTEGER NOT NULL DEFAULT 0,
+    last_login_at  TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS auth_users_email_idx ON auth_users (email);
+
+-- +goose Down
+DROP TABLE IF EXISTS auth_users;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE auth_users ADD COLUMN IF NOT EXISTS last_login_key TEXT;
+
+-- +goose Down
+ALTER TABLE auth_users DROP COLUMN IF EXISTS last_login_key;
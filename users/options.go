@@ -0,0 +1,30 @@
+package users
+
+import (
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+type Option func(*config)
+
+type config struct {
+	log           *slog.Logger
+	nc            *nats.Conn
+	signupSubject string
+}
+
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
+
+// WithSignupEvents publishes a SignupEvent to subject whenever a user logs in
+// for the first time. The default subject is "users.signup".
+func WithSignupEvents(nc *nats.Conn, subject string) Option {
+	return func(c *config) {
+		c.nc = nc
+		if subject != "" {
+			c.signupSubject = subject
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package users
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nats-io/nats.go"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/database/pg"
+)
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const (
+	MigrationsDir   = "migrations"
+	MigrationsTable = "users_goose_db_version"
+)
+
+// User is the local record of an identity provider account, keyed on sub.
+type User struct {
+	ID            uuid.UUID  `json:"id"`
+	Sub           string     `json:"sub"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Name          string     `json:"name"`
+	Picture       string     `json:"picture"`
+	LoginCount    int        `json:"login_count"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// SignupEvent is published when a user logs in for the first time.
+type SignupEvent struct {
+	User User      `json:"user"`
+	At   time.Time `json:"at"`
+}
+
+// Store keeps users in the auth_users table, named so it does not collide
+// with an application's own users table. Apply the schema with
+// pg.Migrate using Migrations, MigrationsDir and MigrationsTable.
+type Store struct {
+	db  *pg.Database
+	cfg config
+}
+
+type userContextKey struct{}
+
+const columns = `id, sub, email, email_verified, name, picture, login_count, last_login_at, created_at, updated_at`
+
+func New(db *pg.Database, opts ...Option) (*Store, error) {
+	cfg := config{
+		log:           slog.Default(),
+		signupSubject: "users.signup",
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	if cfg.log == nil {
+		return nil, ErrNilLogger
+	}
+
+	return &Store{db: db, cfg: cfg}, nil
+}
+
+// RecordLogin creates or updates the user for su, counting the login. created
+// reports whether this was the user's first login. Logins are identified by
+// the ID token's nonce, or auth_time when there is none, so recording the
+// same login again (e.g. a retried hook) does not count it twice.
+func (s *Store) RecordLogin(ctx context.Context, su auth0.SessionUser) (u User, created bool, err error) {
+	key, err := loginKey(su)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	row := s.db.Pool.QueryRow(ctx, `
+		INSERT INTO auth_users (sub, email, email_verified, name, picture, login_count, last_login_at, last_login_key)
+		VALUES ($1, $2, $3, $4, $5, 1, now(), NULLIF($6, ''))
+		ON CONFLICT (sub) DO UPDATE SET
+			email = EXCLUDED.email,
+			email_verified = EXCLUDED.email_verified,
+			name = EXCLUDED.name,
+			picture = EXCLUDED.picture,
+			login_count = auth_users.login_count + CASE
+				WHEN EXCLUDED.last_login_key IS NOT NULL AND EXCLUDED.last_login_key = auth_users.last_login_key THEN 0
+				ELSE 1
+			END,
+			last_login_at = now(),
+			last_login_key = EXCLUDED.last_login_key,
+			updated_at = now()
+		RETURNING `+columns+`, (xmax = 0) AS inserted`,
+		su.Sub, su.Email, su.EmailVerified, su.Name, su.Picture, key,
+	)
+
+	err = row.Scan(&u.ID, &u.Sub, &u.Email, &u.EmailVerified, &u.Name, &u.Picture,
+		&u.LoginCount, &u.LastLoginAt, &u.CreatedAt, &u.UpdatedAt, &created)
+	return u, created, err
+}
+
+// loginKey identifies the login su came from, or returns "" when the claims
+// carry nothing to tell logins apart.
+func loginKey(su auth0.SessionUser) (string, error) {
+	claims, err := su.CustomClaims()
+	if err != nil {
+		return "", err
+	}
+
+	if nonce, ok := claims["nonce"].(string); ok && nonce != "" {
+		return "nonce:" + nonce, nil
+	}
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		return "auth_time:" + strconv.FormatInt(int64(authTime), 10), nil
+	}
+	return "", nil
+}
+
+func (s *Store) BySub(ctx context.Context, sub string) (User, error) {
+	var u User
+	err := s.db.Pool.QueryRow(ctx, `SELECT `+columns+` FROM auth_users WHERE sub = $1`, sub).
+		Scan(&u.ID, &u.Sub, &u.Email, &u.EmailVerified, &u.Name, &u.Picture,
+			&u.LoginCount, &u.LastLoginAt, &u.CreatedAt, &u.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return u, err
+}
+
+// SyncPostLogin returns a hook that records every login and publishes a
+// SignupEvent on the first one. Pass it to auth0.WithPostLoginHook.
+func (s *Store) SyncPostLogin() auth0.PostLoginHook {
+	return func(ctx context.Context, su *auth0.SessionUser, r *http.Request) error {
+		u, created, err := s.RecordLogin(ctx, *su)
+		if err != nil {
+			return err
+		}
+
+		if created {
+			s.cfg.log.Info("new user signed up", "sub", u.Sub, "id", u.ID)
+			if err = s.publishSignup(u); err != nil {
+				// The user row is committed; a lost event must not block login.
+				s.cfg.log.Error("unable to publish signup event", "sub", u.Sub, "error", err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// LocalUserMiddleware loads the local row of the authenticated user into the
+// request context. Run it after the auth0 middleware. Anonymous requests and
+// users without a row pass through unchanged.
+func (s *Store) LocalUserMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			su, ok := auth0.UserFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			u, err := s.BySub(r.Context(), su.Sub)
+			if errors.Is(err, ErrNotFound) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				s.cfg.log.Error("unable to load local user", "sub", su.Sub, "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), u)))
+		})
+	}
+}
+
+func (s *Store) publishSignup(u User) error {
+	if s.cfg.nc == nil {
+		return nil
+	}
+	return publishSignup(s.cfg.nc, s.cfg.signupSubject, u)
+}
+
+func publishSignup(nc *nats.Conn, subject string, u User) error {
+	data, err := json.Marshal(SignupEvent{User: u, At: time.Now()})
+	if err != nil {
+		return err
+	}
+	return nc.Publish(subject, data)
+}
+
+// FromContext returns the local user loaded by LocalUserMiddleware.
+func FromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userContextKey{}).(User)
+	return u, ok
+}
+
+func ContextWithUser(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, u)
+}
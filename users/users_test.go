@@ -0,0 +1,159 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/derekmwright/web/auth/auth0"
+	"github.com/derekmwright/web/database/pg"
+	"github.com/derekmwright/web/nats"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err != ErrNilDatabase {
+		t.Errorf("err = %v, want %v", err, ErrNilDatabase)
+	}
+}
+
+func TestLocalUserMiddlewareAnonymous(t *testing.T) {
+	s := &Store{}
+
+	called := false
+	h := s.LocalUserMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if _, ok := FromContext(r.Context()); ok {
+			t.Error("anonymous request should not have a local user")
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if !called || rr.Code != http.StatusOK {
+		t.Errorf("called = %v, status = %d; want pass-through", called, rr.Code)
+	}
+}
+
+func TestContext(t *testing.T) {
+	u := User{ID: uuid.New(), Sub: "auth0|alice"}
+
+	got, ok := FromContext(ContextWithUser(t.Context(), u))
+	if !ok || got.ID != u.ID || got.Sub != u.Sub {
+		t.Errorf("FromContext = %+v, %v; want %+v", got, ok, u)
+	}
+}
+
+func TestPublishSignup(t *testing.T) {
+	nc, shutdown, err := nats.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	sub, err := nc.SubscribeSync("users.signup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := User{ID: uuid.New(), Sub: "auth0|alice", Email: "alice@example.com"}
+	if err = publishSignup(nc, "users.signup", u); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ev SignupEvent
+	if err = json.Unmarshal(msg.Data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.User.ID != u.ID || ev.User.Email != u.Email || ev.At.IsZero() {
+		t.Errorf("event = %+v, want user %+v", ev, u)
+	}
+}
+
+func TestLoginKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		custom string
+		want   string
+	}{
+		{name: "nonce", custom: `{"nonce":"n-1","auth_time":1700000000}`, want: "nonce:n-1"},
+		{name: "auth_time", custom: `{"auth_time":1700000000}`, want: "auth_time:1700000000"},
+		{name: "none", custom: `{"org_id":"org_acme"}`, want: ""},
+		{name: "no custom claims", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loginKey(auth0.SessionUser{Sub: "auth0|alice", Custom: json.RawMessage(tt.custom)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("loginKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRecordLogin needs a disposable database in TEST_DATABASE_URL.
+func TestRecordLogin(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := pg.New(pg.WithDSN(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = pg.Migrate(db, Migrations, MigrationsDir, pg.WithMigrationsTable(MigrationsTable)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := "auth0|" + uuid.NewString()
+	login := func(custom string) auth0.SessionUser {
+		return auth0.SessionUser{Sub: sub, Name: "Alice", Custom: json.RawMessage(custom)}
+	}
+
+	steps := []struct {
+		name        string
+		user        auth0.SessionUser
+		wantCreated bool
+		wantCount   int
+	}{
+		{name: "signup", user: login(`{"nonce":"n-1"}`), wantCreated: true, wantCount: 1},
+		{name: "retried signup", user: login(`{"nonce":"n-1"}`), wantCount: 1},
+		{name: "second login", user: login(`{"nonce":"n-2"}`), wantCount: 2},
+		{name: "retried second login", user: login(`{"nonce":"n-2"}`), wantCount: 2},
+		{name: "login without key", user: login(``), wantCount: 3},
+		{name: "repeated login without key", user: login(``), wantCount: 4},
+	}
+
+	for _, step := range steps {
+		u, created, err := s.RecordLogin(t.Context(), step.user)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if created != step.wantCreated || u.LoginCount != step.wantCount {
+			t.Errorf("%s: created = %v, login_count = %d; want %v, %d",
+				step.name, created, u.LoginCount, step.wantCreated, step.wantCount)
+		}
+	}
+}